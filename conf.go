@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/auth"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/prestart"
+)
+
+// configWatchInterval 是检查配置文件是否修改的间隔
+const configWatchInterval = 2 * time.Second
+
+// loadConfig 按 默认值 -> 配置文件 -> 环境变量 -> 命令行参数 的顺序生成配置
+func loadConfig() (*config.Config, error) {
+	conf := config.Default()
+	if configFile != "" {
+		if err := config.LoadFile(configFile, conf); err != nil {
+			return nil, err
+		}
+	}
+	config.ApplyEnv(conf)
+	applyFlags(conf)
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	if err := prestart.ValidateConfig(conf.Prestart.Steps); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// applyFlags 用显式指定的命令行参数覆盖配置
+func applyFlags(conf *config.Config) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if set["addr"] || set["port"] {
+		conf.Listen = []string{fmt.Sprintf("tcp://%s:%d", addr, port)}
+	}
+	if set["listen"] {
+		conf.Listen = listenAddrs
+	}
+	if set["unix-socket-mode"] {
+		conf.UnixSocketMode = unixSocketMode
+	}
+	if set["unix-socket-owner"] {
+		conf.UnixSocketOwner = unixSocketOwner
+	}
+	if set["ulimit-nofile-hard"] {
+		conf.UlimitNofileHard = ulimitNofileHard
+	}
+	if set["ulimit-nofile-soft"] {
+		conf.UlimitNofileSoft = ulimitNofileSoft
+	}
+	if set["auto-start"] {
+		conf.Moonlight.AutoStart = autoStart
+	}
+	if set["no-exit-when-app-launch"] && noExitWhenAppLaunch {
+		conf.Watchdog.Enabled = false
+	}
+	if set["auth-token"] {
+		conf.Auth.Token = authToken
+	}
+	if set["auth-hmac-secret"] {
+		conf.Auth.HMACSecret = authHMACSecret
+	}
+	if set["auth-file"] {
+		conf.Auth.File = authFile
+	}
+	if set["init"] {
+		v := initMode
+		conf.Shutdown.Init = &v
+	}
+	if set["child-subreaper"] {
+		conf.Shutdown.ChildSubreaper = childSubreaper
+	}
+	if set["shutdown-grace-period"] {
+		conf.Shutdown.GracePeriod = config.Duration(shutdownGracePeriod)
+	}
+}
+
+// configReloader 重新加载配置文件，加载失败时保留当前配置
+type configReloader struct {
+	authenticator *auth.Authenticator
+	mu            sync.Mutex
+}
+
+func (r *configReloader) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Infof("reload config %s", configFile)
+	newConf, err := loadConfig()
+	if err != nil {
+		log.Errorf("reload config: %v, keep current config", err)
+		return
+	}
+	oldConf := config.Get()
+	if changed := config.RuntimeChanges(oldConf, newConf); len(changed) > 0 {
+		log.Warningf("config %s changed, restart required to take effect", strings.Join(changed, ", "))
+	}
+	config.KeepStatic(oldConf, newConf)
+
+	authConf, err := newConf.BuildAuthConfig()
+	if err != nil {
+		log.Errorf("reload auth config: %v, keep current config", err)
+		return
+	}
+	if err := setupLog(newConf.Log); err != nil {
+		log.Errorf("reload log config: %v, keep current config", err)
+		return
+	}
+	if newConf.Logs != oldConf.Logs {
+		if err := setupAppLogFile(newConf.Logs); err != nil {
+			log.Errorf("reload app log file: %v, keep current config", err)
+			return
+		}
+	}
+	r.authenticator.SetConfig(authConf)
+	config.Set(newConf)
+	log.Infof("config reloaded")
+}
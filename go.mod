@@ -6,6 +6,7 @@ require (
 	github.com/andygrunwald/vdf v1.1.0
 	github.com/gorilla/mux v1.8.1
 	github.com/spf13/cobra v1.9.1
+	gopkg.in/yaml.v3 v3.0.1
 	yunion.io/x/log v1.0.0
 	yunion.io/x/pkg v1.10.3
 )
@@ -4,72 +4,83 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/gorilla/mux"
 	"yunion.io/x/pkg/errors"
 
+	"github.com/zexi/wolf-hook/pkg/applog"
+	"github.com/zexi/wolf-hook/pkg/auth"
+	"github.com/zexi/wolf-hook/pkg/config"
 	"github.com/zexi/wolf-hook/pkg/handlers"
+	"github.com/zexi/wolf-hook/pkg/listener"
+	"github.com/zexi/wolf-hook/pkg/metrics"
 	"github.com/zexi/wolf-hook/pkg/moonlight/client"
+	"github.com/zexi/wolf-hook/pkg/redact"
+	"github.com/zexi/wolf-hook/pkg/server"
 	"github.com/zexi/wolf-hook/pkg/util/procutils"
 
 	"yunion.io/x/log"
 )
 
 var (
+	configFile          string
 	addr                string
 	port                int
 	ulimitNofileHard    int
 	ulimitNofileSoft    int
 	autoStart           bool
 	noExitWhenAppLaunch bool
+	authToken           string
+	authHMACSecret      string
+	authFile            string
+	listenAddrs         stringSliceFlag
+	unixSocketMode      string
+	unixSocketOwner     string
+	initMode            bool
+	childSubreaper      bool
+	shutdownGracePeriod time.Duration
 )
 
+// stringSliceFlag 支持重复指定的命令行参数
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// 命令行参数只有在显式指定时才会覆盖配置文件和环境变量，见 applyFlags
 func init() {
+	flag.StringVar(&configFile, "config", os.Getenv("WOLF_HOOK_CONFIG"), "YAML or JSON config file, reloaded on SIGHUP or change (env WOLF_HOOK_CONFIG)")
 	flag.StringVar(&addr, "addr", "127.0.0.1", "HTTP server listen address")
 	flag.IntVar(&port, "port", 8080, "HTTP server listen port")
 	flag.IntVar(&ulimitNofileHard, "ulimit-nofile-hard", 10240, "ulimit nofile hard")
 	flag.IntVar(&ulimitNofileSoft, "ulimit-nofile-soft", 10240, "ulimit nofile soft")
 	flag.BoolVar(&autoStart, "auto-start", false, "auto start moonlight client after HTTP server starts")
 	flag.BoolVar(&noExitWhenAppLaunch, "no-exit-when-app-launch", false, "do not exit when app launch (skip sway process monitoring)")
+	flag.StringVar(&authToken, "auth-token", "", "bearer token granted all scopes (env WOLF_HOOK_AUTH_TOKEN)")
+	flag.StringVar(&authHMACSecret, "auth-hmac-secret", "", "HMAC secret granted all scopes (env WOLF_HOOK_AUTH_HMAC_SECRET)")
+	flag.StringVar(&authFile, "auth-file", "", "JSON file of tokens/HMAC keys with per-credential scopes (env WOLF_HOOK_AUTH_FILE)")
+	flag.Var(&listenAddrs, "listen", "listen address like tcp://127.0.0.1:8080 or unix:///run/wolf-hook.sock, can be repeated (default tcp://<addr>:<port>)")
+	flag.StringVar(&unixSocketMode, "unix-socket-mode", "0660", "file mode of unix socket listeners")
+	flag.StringVar(&unixSocketOwner, "unix-socket-owner", "", "owner of unix socket listeners in uid:gid format")
+	flag.BoolVar(&initMode, "init", os.Getpid() == 1, "act as init: forward signals to the app and shut down gracefully on SIGTERM/SIGINT (default true when running as pid 1)")
+	flag.BoolVar(&childSubreaper, "child-subreaper", false, "adopt and reap orphaned descendants with PR_SET_CHILD_SUBREAPER when not running as pid 1 (env WOLF_HOOK_CHILD_SUBREAPER)")
+	flag.DurationVar(&shutdownGracePeriod, "shutdown-grace-period", 10*time.Second, "time to wait for the app to exit after forwarding a terminate signal before sending SIGKILL")
 	flag.Parse()
 }
 
-// getConfigFromEnv 从环境变量获取 Moonlight 配置
-func getConfigFromEnv() (string, int, string) {
-	// 从环境变量读取服务器IP，默认为 220.196.214.104
-	hostIP := "127.0.0.1"
-	/*hostIP := os.Getenv("CLOUDPODS_HOST_EIP")
-	if hostIP == "" {
-		hostIP = os.Getenv("CLOUDPODS_HOST_ACCESS_IP")
-		if hostIP == "" {
-			hostIP = "220.196.214.104"
-		}
-	}*/
-
-	// 从环境变量读取HTTP端口，默认为 20008
-	httpPortStr := os.Getenv("WOLF_HTTP_PORT")
-	httpPort := 20008
-	if httpPortStr != "" {
-		if port, err := strconv.Atoi(httpPortStr); err == nil {
-			httpPort = port
-		}
-	}
-
-	// 从环境变量读取客户端ID，默认为 go_client_001
-	clientID := os.Getenv("WOLF_CLIENT_ID")
-	if clientID == "" {
-		clientID = "go_client_001"
-	}
-
-	return hostIP, httpPort, clientID
-}
-
 // checkServerConnectivity 检查服务器IP和HTTP端口是否可达
 func checkServerConnectivity(hostIP string, httpPort int) error {
 	log.Infof("检查服务器连通性: %s:%d", hostIP, httpPort)
@@ -86,9 +97,8 @@ func checkServerConnectivity(hostIP string, httpPort int) error {
 }
 
 // startMoonlightClient 启动 Moonlight 客户端
-func startMoonlightClient() error {
-	// 从环境变量获取配置
-	hostIP, httpPort, clientID := getConfigFromEnv()
+func startMoonlightClient(conf config.MoonlightConfig) error {
+	hostIP, httpPort, clientID := conf.Host, conf.HTTPPort, conf.ClientID
 	log.Infof("Moonlight 配置: 服务器IP=%s, HTTP端口=%d, 客户端ID=%s", hostIP, httpPort, clientID)
 
 	// 检查服务器连通性
@@ -162,31 +172,134 @@ func setupRlimits(hard, soft uint64) error {
 	return nil
 }
 
+// setupLog 设置 wolf-hook 自身的日志级别和日志中隐藏的敏感变量
+func setupLog(conf config.LogConfig) error {
+	r, err := redact.New(conf.Redact)
+	if err != nil {
+		return err
+	}
+	if err := log.SetLogLevelByString(log.Logger(), conf.Level); err != nil {
+		return errors.Wrapf(err, "set log level %q", conf.Level)
+	}
+	log.SetVerboseLevel(int32(conf.Verbose))
+	redact.SetDefault(r)
+	return nil
+}
+
+// setupAppLogFile 根据配置设置应用输出写入的文件
+func setupAppLogFile(conf config.LogsConfig) error {
+	var w io.Writer
+	if conf.File != "" {
+		f, err := applog.OpenRotatingFile(conf.File, int64(conf.MaxSizeMB)<<20, conf.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w = f
+		log.Infof("app output is written to %s", conf.File)
+	}
+	if old, ok := applog.Default().SetFile(w).(io.Closer); ok {
+		old.Close()
+	}
+	return nil
+}
+
+// setupListeners 根据配置创建所有监听
+func setupListeners(conf *config.Config) ([]net.Listener, error) {
+	mode, err := strconv.ParseUint(conf.UnixSocketMode, 8, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid unix socket mode %q", conf.UnixSocketMode)
+	}
+	uid, gid, err := listener.ParseOwner(conf.UnixSocketOwner)
+	if err != nil {
+		return nil, err
+	}
+	opts := listener.UnixSocketOptions{Mode: os.FileMode(mode), UID: uid, GID: gid}
+
+	var ls []net.Listener
+	for _, spec := range conf.Listen {
+		l, err := listen(spec, opts)
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, errors.Wrapf(err, "listen %s", spec)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+func listen(spec string, opts listener.UnixSocketOptions) (net.Listener, error) {
+	laddr, err := listener.ParseAddress(spec)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listener.Listen(laddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Listening on %s", laddr)
+	return l, nil
+}
+
 func main() {
+	// 作为启动辅助进程运行时 exec 应用，不会返回
+	procutils.RunLaunchHelper()
+
 	log.Infof("============= WOLF HOOK ==========")
-	if err := setupRlimits(uint64(ulimitNofileHard), uint64(ulimitNofileSoft)); err != nil {
+	conf, err := loadConfig()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	config.Set(conf)
+	if err := setupLog(conf.Log); err != nil {
+		log.Fatalf("setup log: %v", err)
+	}
+
+	if err := setupRlimits(uint64(conf.UlimitNofileHard), uint64(conf.UlimitNofileSoft)); err != nil {
 		log.Fatalf("setup ulimit nofile hard: %s", err)
 	}
 
-	go procutils.WaitZombieLoop(context.Background())
+	procutils.StartReaper(context.Background(), procutils.ReaperOptions{
+		Subreaper:    conf.Shutdown.ChildSubreaper,
+		OnOrphanExit: handlers.HandleOrphanExit,
+	})
+
+	applog.SetDefault(applog.NewBuffer(conf.Logs.BufferLines))
+	if err := setupAppLogFile(conf.Logs); err != nil {
+		log.Fatalf("setup app log file: %v", err)
+	}
 
+	authConf, err := conf.BuildAuthConfig()
+	if err != nil {
+		log.Fatalf("load auth config: %v", err)
+	}
+	if !authConf.Enabled() {
+		log.Warningf("no auth token or HMAC key configured, hook API is unauthenticated")
+	}
+	authenticator := auth.NewAuthenticator(authConf)
+
+	// 写超时由 server.NewRouter 按路由设置，事件流等长连接不受限制
 	srv := &http.Server{
-		Handler:      getHandler(),
-		Addr:         fmt.Sprintf("%s:%d", addr, port),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		Handler:     server.NewRouter(authenticator),
+		ReadTimeout: 15 * time.Second,
 	}
 
-	log.Infof("Listening on %s:%d", addr, port)
+	listeners, err := setupListeners(conf)
+	if err != nil {
+		log.Fatalf("setup listeners: %v", err)
+	}
 
 	// 如果启用了自动启动，在后台启动 Moonlight 客户端
-	if autoStart {
+	if conf.Moonlight.AutoStart {
 		go func() {
 			// 等待一小段时间确保 HTTP 服务完全启动
 			for {
 				time.Sleep(1 * time.Second)
-				if err := startMoonlightClient(); err != nil {
+				metrics.MoonlightAutoStartAttempts.Inc()
+				if err := startMoonlightClient(conf.Moonlight); err != nil {
 					log.Errorf("start moonlight client: %v", err)
+					metrics.MoonlightAutoStartFailures.Inc()
 				} else {
 					break
 				}
@@ -194,18 +307,28 @@ func main() {
 		}()
 	}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatalf("listen and serve: %v", err)
+	var reload func()
+	if configFile != "" {
+		reloader := &configReloader{authenticator: authenticator}
+		reload = reloader.Reload
+		go config.Watch(context.Background(), configFile, configWatchInterval, reload)
+	}
+	if conf.InitMode() {
+		go handleSignals(srv, reload)
+	} else if reload != nil {
+		go handleReloadSignal(reload)
 	}
-}
 
-func getHandler() http.Handler {
-	r := mux.NewRouter()
-	r.Handle("/hook/start", handlers.NewStartController(noExitWhenAppLaunch)).Methods("POST")
-	r.Handle("/hook/stop", handlers.NewStopController()).Methods("POST")
-	r.Handle("/hook/status", handlers.NewGetStatusController()).Methods("GET")
-	r.Handle("/hook/exec", handlers.NewExecController()).Methods("POST")
-	r.Handle("/hook/write-hwdb", handlers.NewWriteHwdbController()).Methods("POST")
-	r.Handle("/steam/owned-games", handlers.NewSteamOwnedGamesController()).Methods("GET")
-	return r
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errCh <- errors.Wrapf(srv.Serve(l), "serve %s", l.Addr())
+		}(l)
+	}
+	err = <-errCh
+	if errors.Cause(err) == http.ErrServerClosed {
+		// 由 shutdown 负责退出
+		select {}
+	}
+	log.Fatalf("listen and serve: %v", err)
 }
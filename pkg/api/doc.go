@@ -0,0 +1,4 @@
+// Package api 定义 wolf-hook HTTP 接口的请求和响应类型。
+// 服务端和客户端共用这些类型，这个包只依赖不会产生副作用的轻量的包，
+// 客户端引用时不会引入服务端的配置、指标和启动步骤
+package api
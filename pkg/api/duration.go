@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// Duration 支持 "10s" 这样的字符串或者以秒为单位的数字
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case float64:
+		*d = Duration(val * float64(time.Second))
+	case string:
+		dur, err := time.ParseDuration(val)
+		if err != nil {
+			return errors.Wrapf(err, "invalid duration %q", val)
+		}
+		*d = Duration(dur)
+	default:
+		return errors.Errorf("invalid duration %s", string(b))
+	}
+	return nil
+}
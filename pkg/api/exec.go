@@ -0,0 +1,14 @@
+package api
+
+// ExecParams 是 /hook/exec 的请求参数
+type ExecParams struct {
+	Cmd  string   `json:"cmd"`  // 要执行的命令
+	Args []string `json:"args"` // 命令参数
+	User string   `json:"user"` // 执行命令的用户名或 uid，为空时以 wolf-hook 的身份执行
+}
+
+// ExecResponse 是 /hook/exec 的响应
+type ExecResponse struct {
+	Output string `json:"output"`          // 命令输出
+	Error  string `json:"error,omitempty"` // 错误信息，如果有的话
+}
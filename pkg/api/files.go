@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/zexi/wolf-hook/pkg/ownership"
+)
+
+// WriteHwdbParams 是 /hook/write-hwdb 的请求参数
+type WriteHwdbParams struct {
+	Path    string `json:"path"`    // 文件路径
+	Content string `json:"content"` // 文件内容
+}
+
+// OwnershipParams 是 /hook/ownership 的请求参数
+type OwnershipParams struct {
+	// Names 按名字选择配置中 ownership.rules 的规则，为空时执行所有规则。
+	// 只能执行配置中的规则，不接受请求中的任意路径，避免绕过 policies 中的限制
+	Names  []string `json:"names,omitempty"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// OwnershipResponse 是 /hook/ownership 的响应
+type OwnershipResponse struct {
+	*ownership.Report
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"github.com/zexi/wolf-hook/pkg/util/procfs"
+)
+
+// ProcessNode 是进程树中的一个进程
+type ProcessNode struct {
+	*procfs.Process
+	Children []*ProcessNode `json:"children,omitempty"`
+}
+
+// ProcessesResponse 是 /hook/processes 的响应
+type ProcessesResponse struct {
+	// AppPgid 是应用进程组 id，没有启动应用时为 0
+	AppPgid int `json:"app_pgid,omitempty"`
+	// Processes 默认按 pid 排序，tree=true 时只包含根进程，其余进程在 children 中
+	Processes []*ProcessNode `json:"processes"`
+}
+
+// SignalTargetApp 表示向应用入口进程所在的进程组发送信号
+const SignalTargetApp = "app"
+
+// SignalParams 是 /hook/signal 的请求参数，pid、name、cmdline 和 target 只能指定一个
+type SignalParams struct {
+	// Signal 是信号名或者信号值，例如 "SIGUSR1"、"USR1" 或 "10"
+	Signal string `json:"signal"`
+	PID    int    `json:"pid,omitempty"`
+	// Name 和进程名完全匹配，超过 15 个字符时按内核截断后的进程名匹配
+	Name string `json:"name,omitempty"`
+	// Cmdline 是匹配以空格连接的命令行的正则表达式
+	Cmdline string `json:"cmdline,omitempty"`
+	// Target 为 "app" 时匹配应用进程组中的所有进程
+	Target string `json:"target,omitempty"`
+}
+
+// SignalledProcess 是一个匹配到的进程和发送信号的结果
+type SignalledProcess struct {
+	PID       int    `json:"pid"`
+	Comm      string `json:"comm"`
+	Cmdline   string `json:"cmdline,omitempty"`
+	Signalled bool   `json:"signalled"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SignalResponse 是 /hook/signal 的响应
+type SignalResponse struct {
+	Signal    string             `json:"signal"`
+	Processes []SignalledProcess `json:"processes"`
+	Error     string             `json:"error,omitempty"`
+}
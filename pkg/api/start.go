@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/zexi/wolf-hook/pkg/resources"
+)
+
+// HeaderIdempotencyKey 是携带幂等键的请求头，和 StartParams.IdempotencyKey 等价
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// StartParams 是 /hook/start 的请求参数
+type StartParams struct {
+	Envs map[string]string `json:"envs"`
+	// Command 为空时使用配置中的 entrypoint.command
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	WorkDir string   `json:"workdir,omitempty"`
+	// User 是运行应用的用户名或 uid，需要开启 entrypoint.allow_user_override
+	User string `json:"user,omitempty"`
+	UID  *int   `json:"uid,omitempty"`
+	GID  *int   `json:"gid,omitempty"`
+	// Umask 是八进制字符串，例如 "0022"
+	Umask string `json:"umask,omitempty"`
+	// Rlimits 和 Cgroup 覆盖配置中的资源限制，需要开启 resources.allow_override
+	Rlimits map[string]resources.Rlimit `json:"rlimits,omitempty"`
+	Cgroup  *resources.CgroupLimits     `json:"cgroup,omitempty"`
+	// IdempotencyKey 和正在进行的运行相同的重复请求返回该运行而不会再次启动应用
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// StartResponse 是 /hook/start 的响应
+type StartResponse struct {
+	RunID          string `json:"run_id"`
+	State          STATE  `json:"state"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Existing 为 true 表示请求与已有的运行匹配，没有重新启动应用
+	Existing bool   `json:"existing,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"time"
+
+	"github.com/zexi/wolf-hook/pkg/resources"
+)
+
+// DefaultReadinessTimeout 是 readiness.timeout 的默认值，
+// 客户端等待应用就绪时需要等待比它更长的时间
+const DefaultReadinessTimeout = 60 * time.Second
+
+// STATE 是应用生命周期的状态
+type STATE string
+
+const (
+	STATE_IDLE      STATE = "IDLE"
+	STATE_PREPARING STATE = "PREPARING"
+	STATE_STARTING  STATE = "STARTING"
+	STATE_RUNNING   STATE = "RUNNING"
+	STATE_READY     STATE = "READY"
+	STATE_STOPPING  STATE = "STOPPING"
+	STATE_EXITED    STATE = "EXITED"
+	STATE_FAILED    STATE = "FAILED"
+)
+
+// 兼容旧版本 /hook/status 文本输出的状态
+const (
+	LEGACY_STATE_RUNNING = "RUNNING"
+	LEGACY_STATE_STOPPED = "STOPPED"
+	LEGACY_STATE_ERROR   = "ERROR"
+)
+
+// Legacy 返回旧版本使用的状态文本
+func (s STATE) Legacy() string {
+	switch s {
+	case STATE_PREPARING, STATE_STARTING, STATE_RUNNING, STATE_READY:
+		return LEGACY_STATE_RUNNING
+	case STATE_STOPPING, STATE_EXITED:
+		return LEGACY_STATE_STOPPED
+	case STATE_FAILED:
+		return LEGACY_STATE_ERROR
+	}
+	return ""
+}
+
+// IsActive 返回该状态下是否有一次运行正在进行
+func (s STATE) IsActive() bool {
+	switch s {
+	case STATE_PREPARING, STATE_STARTING, STATE_RUNNING, STATE_READY, STATE_STOPPING:
+		return true
+	}
+	return false
+}
+
+// Status 是应用生命周期的状态快照
+type Status struct {
+	State STATE  `json:"state"`
+	RunID string `json:"run_id,omitempty"`
+	// IdempotencyKey 是启动本次运行的请求携带的幂等键
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	PID            int    `json:"pid,omitempty"`
+	ExitCode       *int   `json:"exit_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	// Steps 是本次运行启动前准备步骤的结果
+	Steps []StepResult `json:"steps,omitempty"`
+	// Watchdog 是本次运行看门狗的状态
+	Watchdog *WatchdogStatus `json:"watchdog,omitempty"`
+	// Restart 是自动重启的状态，在自动重启的各次运行之间保留
+	Restart *RestartStatus `json:"restart,omitempty"`
+	// Resources 是应用所在 cgroup 当前的资源使用，只在 /hook/status 中返回
+	Resources *resources.Usage `json:"resources,omitempty"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	// 本次运行进入各个状态的时间
+	Timestamps map[STATE]time.Time `json:"timestamps,omitempty"`
+}
+
+// Copy 返回深拷贝，修改返回值不会影响原状态
+func (s Status) Copy() Status {
+	ret := s
+	if s.ExitCode != nil {
+		code := *s.ExitCode
+		ret.ExitCode = &code
+	}
+	ret.Steps = append([]StepResult(nil), s.Steps...)
+	if s.Watchdog != nil {
+		wd := *s.Watchdog
+		ret.Watchdog = &wd
+	}
+	if s.Restart != nil {
+		rs := s.Restart.Copy()
+		ret.Restart = &rs
+	}
+	ret.Timestamps = make(map[STATE]time.Time, len(s.Timestamps))
+	for k, v := range s.Timestamps {
+		ret.Timestamps[k] = v
+	}
+	return ret
+}
+
+// RestartStatus 是自动重启的状态，从最近一次 /hook/start 开始累计
+type RestartStatus struct {
+	Policy string `json:"policy"`
+	// Count 是自动重启的次数，包括看门狗触发的重启
+	Count int `json:"count"`
+	// LastExitCodes 是最近几次运行入口进程的退出码，-1 表示没有启动或者退出码未知
+	LastExitCodes []int      `json:"last_exit_codes,omitempty"`
+	NextRestartAt *time.Time `json:"next_restart_at,omitempty"`
+	// LimitReached 为 true 表示窗口内的重启次数达到上限，不再自动重启
+	LimitReached bool `json:"limit_reached,omitempty"`
+}
+
+// Copy 返回深拷贝
+func (r RestartStatus) Copy() RestartStatus {
+	ret := r
+	ret.LastExitCodes = append([]int(nil), r.LastExitCodes...)
+	if r.NextRestartAt != nil {
+		t := *r.NextRestartAt
+		ret.NextRestartAt = &t
+	}
+	return ret
+}
+
+// StepResult 是一个启动前准备步骤的执行结果
+type StepResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	// Skipped 为 true 表示步骤被禁用
+	Skipped bool `json:"skipped,omitempty"`
+	// ContinueOnFailure 为 true 表示步骤失败后继续执行后续步骤
+	ContinueOnFailure bool       `json:"continue_on_failure,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	StartedAt         *time.Time `json:"started_at,omitempty"`
+	DurationMs        int64      `json:"duration_ms"`
+}
+
+// WatchdogStatus 是看门狗的状态
+type WatchdogStatus struct {
+	Probe               string     `json:"probe"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	FailureThreshold    int        `json:"failure_threshold"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	// Action 是连续失败达到阈值后执行的动作
+	Action string `json:"action"`
+	// TriggeredAt 是最近一次执行动作的时间
+	TriggeredAt *time.Time `json:"triggered_at,omitempty"`
+}
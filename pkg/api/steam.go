@@ -0,0 +1,17 @@
+package api
+
+// Game 是 Steam 库中的一个游戏
+type Game struct {
+	AppID      int    `json:"appid"`
+	Name       string `json:"name"`
+	PlayTime   int    `json:"playtime_forever"`
+	PlayTime2W int    `json:"playtime_2weeks,omitempty"`
+}
+
+// OwnedGamesResponse 是 /steam/owned-games 的响应，格式和 Steam Web API 的 GetOwnedGames 相同
+type OwnedGamesResponse struct {
+	Response struct {
+		GameCount int    `json:"game_count"`
+		Games     []Game `json:"games"`
+	} `json:"response"`
+}
@@ -0,0 +1,43 @@
+package api
+
+// 停止结果中单个进程的结果
+const (
+	StopResultTerminated = "terminated"
+	StopResultKilled     = "killed"
+	StopResultSurvived   = "survived"
+)
+
+// StopParams 是 /hook/stop 的请求参数，请求体为空时全部使用默认值
+type StopParams struct {
+	// GracePeriod 是发送 SIGTERM 后等待的时间，为空时使用 shutdown.grace_period
+	GracePeriod *Duration `json:"grace_period,omitempty"`
+	// Exit 为 true 时停止应用后退出 wolf-hook
+	Exit bool `json:"exit,omitempty"`
+	// ExitCode 是退出 wolf-hook 使用的退出码，为空时为 123
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// StoppedProcess 是停止时一个进程的处理结果
+type StoppedProcess struct {
+	PID     int    `json:"pid"`
+	Comm    string `json:"comm"`
+	Cmdline string `json:"cmdline,omitempty"`
+	// Signals 是依次发送给进程的信号
+	Signals []string `json:"signals"`
+	// Result 是 terminated、killed 或 survived
+	Result string `json:"result"`
+	// ExitCode 只有应用入口进程才有
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// StopResponse 是 /hook/stop 的响应
+type StopResponse struct {
+	RunID         string           `json:"run_id,omitempty"`
+	State         STATE            `json:"state"`
+	GracePeriodMs int64            `json:"grace_period_ms"`
+	Processes     []StoppedProcess `json:"processes"`
+	// Exiting 为 true 表示 wolf-hook 会在响应返回后退出
+	Exiting  bool   `json:"exiting,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
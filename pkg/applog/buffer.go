@@ -0,0 +1,238 @@
+package applog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+const (
+	DefaultBufferLines = 5000
+
+	subscriberQueueSize = 1024
+	// 超过该长度的行会被截断为多行
+	maxLineSize = 64 * 1024
+)
+
+// Line 是应用输出的一行
+type Line struct {
+	Seq    uint64    `json:"seq"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	RunID  string    `json:"run_id,omitempty"`
+	Text   string    `json:"text"`
+}
+
+// Subscription 订阅新写入的行，订阅方处理过慢时 C 会被关闭
+type Subscription struct {
+	C   <-chan Line
+	ch  chan Line
+	buf *Buffer
+}
+
+func (s *Subscription) Close() {
+	s.buf.unsubscribe(s)
+}
+
+// Buffer 在环形缓冲区中保存最近的应用输出，并可以同时写入文件
+type Buffer struct {
+	mu      sync.Mutex
+	nextSeq uint64
+	lines   []Line
+	start   int
+	size    int
+	subs    map[*Subscription]struct{}
+	file    io.Writer
+}
+
+func NewBuffer(size int) *Buffer {
+	if size <= 0 {
+		size = DefaultBufferLines
+	}
+	return &Buffer{
+		nextSeq: 1,
+		lines:   make([]Line, size),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// SetFile 设置额外写入的文件，nil 表示不写文件，返回之前的文件
+func (b *Buffer) SetFile(w io.Writer) io.Writer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old := b.file
+	b.file = w
+	return old
+}
+
+// Append 追加一行输出
+func (b *Buffer) Append(stream, runID, text string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	line := Line{
+		Seq:    b.nextSeq,
+		Time:   time.Now(),
+		Stream: stream,
+		RunID:  runID,
+		Text:   text,
+	}
+	b.nextSeq++
+	if b.size < len(b.lines) {
+		b.lines[(b.start+b.size)%len(b.lines)] = line
+		b.size++
+	} else {
+		b.lines[b.start] = line
+		b.start = (b.start + 1) % len(b.lines)
+	}
+	if b.file != nil {
+		io.WriteString(b.file, line.Time.Format(time.RFC3339Nano)+" "+stream+" "+text+"\n")
+	}
+	for sub := range b.subs {
+		select {
+		case sub.ch <- line:
+		default:
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Filter 过滤输出，字段为空时不做限制
+type Filter struct {
+	Stream string
+	RunID  string
+}
+
+func (f Filter) Match(l Line) bool {
+	if f.Stream != "" && f.Stream != l.Stream {
+		return false
+	}
+	if f.RunID != "" && f.RunID != l.RunID {
+		return false
+	}
+	return true
+}
+
+// Tail 返回最后 n 行符合过滤条件的输出，n 小于 0 时返回全部；
+// follow 为 true 时同时返回之后新写入行的订阅
+func (b *Buffer) Tail(n int, filter Filter, follow bool) ([]Line, *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ret []Line
+	for i := b.size - 1; i >= 0 && (n < 0 || len(ret) < n); i-- {
+		l := b.lines[(b.start+i)%len(b.lines)]
+		if filter.Match(l) {
+			ret = append(ret, l)
+		}
+	}
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	if !follow {
+		return ret, nil
+	}
+	ch := make(chan Line, subscriberQueueSize)
+	sub := &Subscription{C: ch, ch: ch, buf: b}
+	b.subs[sub] = struct{}{}
+	return ret, sub
+}
+
+func (b *Buffer) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Writer 把写入的内容按行追加到 Buffer，并原样写入 mirror
+type Writer struct {
+	buf     *Buffer
+	stream  string
+	runID   string
+	mirror  io.Writer
+	mu      sync.Mutex
+	partial []byte
+}
+
+// NewWriter 返回 stream 的 Writer，mirror 为空时不镜像输出
+func (b *Buffer) NewWriter(stream, runID string, mirror io.Writer) *Writer {
+	return &Writer{
+		buf:    b,
+		stream: stream,
+		runID:  runID,
+		mirror: mirror,
+	}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.mirror != nil {
+		w.mirror.Write(p)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := p
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			w.partial = append(w.partial, data...)
+			if len(w.partial) >= maxLineSize {
+				w.flushLocked()
+			}
+			break
+		}
+		w.partial = append(w.partial, data[:idx]...)
+		w.flushLocked()
+		data = data[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *Writer) flushLocked() {
+	text := string(bytes.TrimSuffix(w.partial, []byte{'\r'}))
+	w.partial = w.partial[:0]
+	w.buf.Append(w.stream, w.runID, text)
+}
+
+// Close 把最后不完整的一行写入 Buffer
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.flushLocked()
+	}
+	return nil
+}
+
+var (
+	defaultBuffer     = NewBuffer(DefaultBufferLines)
+	defaultBufferLock sync.RWMutex
+)
+
+func Default() *Buffer {
+	defaultBufferLock.RLock()
+	defer defaultBufferLock.RUnlock()
+
+	return defaultBuffer
+}
+
+// SetDefault 替换默认的 Buffer，需要在应用启动前调用
+func SetDefault(b *Buffer) {
+	defaultBufferLock.Lock()
+	defer defaultBufferLock.Unlock()
+
+	defaultBuffer = b
+}
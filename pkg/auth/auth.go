@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// Scope 表示调用方被授权的操作范围
+type Scope string
+
+const (
+	ScopeAll   Scope = "*"
+	ScopeRead  Scope = "read"
+	ScopeStart Scope = "start"
+	ScopeStop  Scope = "stop"
+	ScopeExec  Scope = "exec"
+	ScopeWrite Scope = "write"
+)
+
+const (
+	HeaderKeyID     = "X-Wolf-Hook-Key-Id"
+	HeaderTimestamp = "X-Wolf-Hook-Timestamp"
+	HeaderNonce     = "X-Wolf-Hook-Nonce"
+	HeaderSignature = "X-Wolf-Hook-Signature"
+
+	DefaultMaxClockSkew = 5 * time.Minute
+
+	// 参与签名的请求体最大长度
+	maxSignedBodySize = 32 << 20
+)
+
+// ErrBodyTooLarge 表示签名请求的请求体超过了 maxSignedBodySize，无法完整校验签名
+const ErrBodyTooLarge = errors.Error("request body too large")
+
+// Token 是一个 bearer token 凭据
+type Token struct {
+	Name   string  `json:"name"`
+	Token  string  `json:"token"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// HMACKey 是一个 HMAC 签名凭据
+type HMACKey struct {
+	ID     string  `json:"id"`
+	Secret string  `json:"secret"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// Config 是鉴权配置，可以从文件加载
+type Config struct {
+	Tokens       []Token   `json:"tokens"`
+	HMACKeys     []HMACKey `json:"hmac_keys"`
+	MaxClockSkew string    `json:"max_clock_skew"`
+}
+
+// LoadConfigFile 从 JSON 文件加载鉴权配置
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", path)
+	}
+	conf := new(Config)
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+	return conf, nil
+}
+
+// Enabled 返回是否配置了任何凭据
+func (c *Config) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.HMACKeys) > 0
+}
+
+func (c *Config) Validate() error {
+	for i, t := range c.Tokens {
+		if t.Token == "" {
+			return errors.Errorf("tokens[%d]: empty token", i)
+		}
+	}
+	ids := make(map[string]bool)
+	for i, k := range c.HMACKeys {
+		if k.Secret == "" {
+			return errors.Errorf("hmac_keys[%d]: empty secret", i)
+		}
+		if ids[k.ID] {
+			return errors.Errorf("hmac_keys[%d]: duplicate id %q", i, k.ID)
+		}
+		ids[k.ID] = true
+	}
+	if c.MaxClockSkew != "" {
+		if _, err := time.ParseDuration(c.MaxClockSkew); err != nil {
+			return errors.Wrapf(err, "invalid max_clock_skew %q", c.MaxClockSkew)
+		}
+	}
+	return nil
+}
+
+// MergeCredentials 合并 base 中的凭据、凭据文件以及单独指定的 token 和 HMAC secret，
+// 单独指定的 token 和 HMAC secret 拥有全部权限
+func MergeCredentials(base *Config, token, hmacSecret, file string) (*Config, error) {
+	conf := new(Config)
+	if base != nil {
+		conf.Tokens = append(conf.Tokens, base.Tokens...)
+		conf.HMACKeys = append(conf.HMACKeys, base.HMACKeys...)
+		conf.MaxClockSkew = base.MaxClockSkew
+	}
+	if file != "" {
+		fileConf, err := LoadConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		conf.Tokens = append(conf.Tokens, fileConf.Tokens...)
+		conf.HMACKeys = append(conf.HMACKeys, fileConf.HMACKeys...)
+		if fileConf.MaxClockSkew != "" {
+			conf.MaxClockSkew = fileConf.MaxClockSkew
+		}
+	}
+	if token != "" {
+		conf.Tokens = append(conf.Tokens, Token{Name: "default", Token: token, Scopes: []Scope{ScopeAll}})
+	}
+	if hmacSecret != "" {
+		conf.HMACKeys = append(conf.HMACKeys, HMACKey{Secret: hmacSecret, Scopes: []Scope{ScopeAll}})
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid auth config")
+	}
+	return conf, nil
+}
+
+// Authenticator 校验请求凭据并检查路由所需的 scope
+type Authenticator struct {
+	conf    *Config
+	skew    time.Duration
+	confMu  sync.RWMutex
+	nonces  map[string]time.Time
+	nonceMu sync.Mutex
+}
+
+func NewAuthenticator(conf *Config) *Authenticator {
+	a := &Authenticator{
+		nonces: make(map[string]time.Time),
+	}
+	a.SetConfig(conf)
+	return a
+}
+
+// SetConfig 替换凭据配置，用于配置热加载
+func (a *Authenticator) SetConfig(conf *Config) {
+	if conf == nil {
+		conf = new(Config)
+	}
+	skew := DefaultMaxClockSkew
+	if conf.MaxClockSkew != "" {
+		if d, err := time.ParseDuration(conf.MaxClockSkew); err == nil {
+			skew = d
+		}
+	}
+
+	a.confMu.Lock()
+	defer a.confMu.Unlock()
+
+	a.conf = conf
+	a.skew = skew
+}
+
+func (a *Authenticator) config() (*Config, time.Duration) {
+	a.confMu.RLock()
+	defer a.confMu.RUnlock()
+
+	return a.conf, a.skew
+}
+
+// ErrorResponse 是鉴权失败时返回的 JSON 结构
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Error   string `json:"error"`
+	Details string `json:"details"`
+}
+
+func writeError(w http.ResponseWriter, code int, details string) {
+	w.Header().Set("Content-Type", "application/json")
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="wolf-hook"`)
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Code:    code,
+		Error:   http.StatusText(code),
+		Details: details,
+	})
+}
+
+// Require 包装 handler，要求调用方拥有指定的 scope；
+// 未配置任何凭据时不做校验
+func (a *Authenticator) Require(scope Scope, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf, skew := a.config()
+		if !conf.Enabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name, scopes, err := a.authenticate(r, conf, skew)
+		if errors.Cause(err) == ErrBodyTooLarge {
+			log.Warningf("request %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err != nil {
+			log.Warningf("unauthorized request %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if !hasScope(scopes, scope) {
+			log.Warningf("credential %q lacks scope %q for %s %s", name, scope, r.Method, r.URL.Path)
+			writeError(w, http.StatusForbidden, fmt.Sprintf("credential %q lacks scope %q", name, scope))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func hasScope(scopes []Scope, want Scope) bool {
+	for _, s := range scopes {
+		if s == ScopeAll || s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) authenticate(r *http.Request, conf *Config, skew time.Duration) (string, []Scope, error) {
+	if r.Header.Get(HeaderSignature) != "" {
+		return a.authenticateHMAC(r, conf, skew)
+	}
+	authz := r.Header.Get("Authorization")
+	if authz == "" {
+		return "", nil, errors.Errorf("missing credentials")
+	}
+	const prefix = "Bearer "
+	if len(authz) <= len(prefix) || !strings.EqualFold(authz[:len(prefix)], prefix) {
+		return "", nil, errors.Errorf("unsupported authorization scheme")
+	}
+	token := strings.TrimSpace(authz[len(prefix):])
+	for _, t := range conf.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t.Name, t.Scopes, nil
+		}
+	}
+	return "", nil, errors.Errorf("invalid token")
+}
+
+func (a *Authenticator) authenticateHMAC(r *http.Request, conf *Config, skew time.Duration) (string, []Scope, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	var key *HMACKey
+	for i := range conf.HMACKeys {
+		if conf.HMACKeys[i].ID == keyID {
+			key = &conf.HMACKeys[i]
+			break
+		}
+	}
+	if key == nil {
+		return "", nil, errors.Errorf("unknown key id %q", keyID)
+	}
+
+	tsStr := r.Header.Get(HeaderTimestamp)
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return "", nil, errors.Errorf("invalid timestamp %q", tsStr)
+	}
+	now := time.Now()
+	reqTime := time.Unix(ts, 0)
+	if reqTime.Before(now.Add(-skew)) || reqTime.After(now.Add(skew)) {
+		return "", nil, errors.Errorf("timestamp %d outside allowed clock skew %s", ts, skew)
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return "", nil, errors.Errorf("missing nonce")
+	}
+
+	// 多读一个字节判断是否超过限制，截断后的请求体不能用于校验签名
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "read body")
+	}
+	if len(body) > maxSignedBodySize {
+		return "", nil, errors.Wrapf(ErrBodyTooLarge, "signed body exceeds %d bytes", maxSignedBodySize)
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expect := Sign(key.Secret, r.Method, r.URL.RequestURI(), tsStr, nonce, body)
+	got := r.Header.Get(HeaderSignature)
+	if !hmac.Equal([]byte(expect), []byte(strings.ToLower(got))) {
+		return "", nil, errors.Errorf("signature mismatch")
+	}
+	// 签名校验通过后才记录 nonce，避免伪造请求占满缓存
+	if !a.useNonce(key.ID+"/"+nonce, now, skew) {
+		return "", nil, errors.Errorf("nonce %q already used", nonce)
+	}
+	name := key.ID
+	if name == "" {
+		name = "hmac"
+	}
+	return name, key.Scopes, nil
+}
+
+// useNonce 记录 nonce，已使用过则返回 false
+func (a *Authenticator) useNonce(nonce string, now time.Time, skew time.Duration) bool {
+	a.nonceMu.Lock()
+	defer a.nonceMu.Unlock()
+
+	for n, expire := range a.nonces {
+		if now.After(expire) {
+			delete(a.nonces, n)
+		}
+	}
+	if _, ok := a.nonces[nonce]; ok {
+		return false
+	}
+	// 时间戳在 ±skew 内都有效，nonce 需要至少保留 2*skew
+	a.nonces[nonce] = now.Add(2 * skew)
+	return true
+}
+
+// Sign 计算请求签名：
+// hex(HMAC-SHA256(secret, METHOD\nREQUEST_URI\nTIMESTAMP\nNONCE\nhex(SHA256(body))))
+func Sign(secret, method, requestURI, timestamp, nonce string, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", strings.ToUpper(method), requestURI, timestamp, nonce, hex.EncodeToString(bodySum[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,272 @@
+package auth_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zexi/wolf-hook/pkg/auth"
+)
+
+const (
+	testToken     = "test-token"
+	testReadToken = "test-read-token"
+	testKeyID     = "k1"
+	testSecret    = "s3cret"
+)
+
+func testConfig() *auth.Config {
+	return &auth.Config{
+		Tokens: []auth.Token{
+			{Name: "admin", Token: testToken, Scopes: []auth.Scope{auth.ScopeAll}},
+			{Name: "reader", Token: testReadToken, Scopes: []auth.Scope{auth.ScopeRead}},
+		},
+		HMACKeys: []auth.HMACKey{
+			{ID: testKeyID, Secret: testSecret, Scopes: []auth.Scope{auth.ScopeStart}},
+		},
+	}
+}
+
+// echoHandler 返回收到的请求体，用于检查签名校验后请求体仍然可以读取
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Write(body)
+})
+
+func serve(a *auth.Authenticator, scope auth.Scope, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.Require(scope, echoHandler).ServeHTTP(w, r)
+	return w
+}
+
+func tokenRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/hook/status", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+type signedRequest struct {
+	keyID  string
+	secret string
+	ts     time.Time
+	nonce  string
+	body   []byte
+}
+
+func (s signedRequest) build() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/hook/start?wait=true", bytes.NewReader(s.body))
+	tsStr := strconv.FormatInt(s.ts.Unix(), 10)
+	r.Header.Set(auth.HeaderKeyID, s.keyID)
+	r.Header.Set(auth.HeaderTimestamp, tsStr)
+	r.Header.Set(auth.HeaderNonce, s.nonce)
+	r.Header.Set(auth.HeaderSignature, auth.Sign(s.secret, r.Method, r.URL.RequestURI(), tsStr, s.nonce, s.body))
+	return r
+}
+
+func newSigned(nonce string) signedRequest {
+	return signedRequest{
+		keyID:  testKeyID,
+		secret: testSecret,
+		ts:     time.Now(),
+		nonce:  nonce,
+		body:   []byte(`{"envs":{"A":"1"}}`),
+	}
+}
+
+func errorDetails(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	resp := new(auth.ErrorResponse)
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatalf("decode error response %q: %v", w.Body.String(), err)
+	}
+	if resp.Code != w.Code {
+		t.Errorf("error response code = %d, status = %d", resp.Code, w.Code)
+	}
+	return resp.Details
+}
+
+func TestNoCredentialsConfigured(t *testing.T) {
+	a := auth.NewAuthenticator(nil)
+	if w := serve(a, auth.ScopeExec, tokenRequest("")); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 when auth is disabled", w.Code)
+	}
+}
+
+func TestToken(t *testing.T) {
+	a := auth.NewAuthenticator(testConfig())
+
+	cases := []struct {
+		name  string
+		req   *http.Request
+		scope auth.Scope
+		want  int
+	}{
+		{name: "valid token", req: tokenRequest(testToken), scope: auth.ScopeExec, want: http.StatusOK},
+		{name: "read scope", req: tokenRequest(testReadToken), scope: auth.ScopeRead, want: http.StatusOK},
+		{name: "missing scope", req: tokenRequest(testReadToken), scope: auth.ScopeStart, want: http.StatusForbidden},
+		{name: "wrong token", req: tokenRequest("nope"), scope: auth.ScopeRead, want: http.StatusUnauthorized},
+		{name: "missing credentials", req: tokenRequest(""), scope: auth.ScopeRead, want: http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := serve(a, c.scope, c.req)
+			if w.Code != c.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, c.want, w.Body.String())
+			}
+			if c.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("401 response without WWW-Authenticate")
+			}
+			if c.want != http.StatusOK {
+				errorDetails(t, w)
+			}
+		})
+	}
+
+	r := tokenRequest("")
+	r.Header.Set("Authorization", "Basic "+testToken)
+	if w := serve(a, auth.ScopeRead, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("basic scheme status = %d, want 401", w.Code)
+	}
+	r = tokenRequest("")
+	r.Header.Set("Authorization", "bearer "+testToken)
+	if w := serve(a, auth.ScopeRead, r); w.Code != http.StatusOK {
+		t.Errorf("lower case bearer scheme status = %d, want 200", w.Code)
+	}
+}
+
+func TestSetConfigReplacesTokens(t *testing.T) {
+	a := auth.NewAuthenticator(testConfig())
+	a.SetConfig(&auth.Config{Tokens: []auth.Token{{Name: "new", Token: "new-token", Scopes: []auth.Scope{auth.ScopeAll}}}})
+
+	if w := serve(a, auth.ScopeRead, tokenRequest(testToken)); w.Code != http.StatusUnauthorized {
+		t.Errorf("old token status = %d, want 401", w.Code)
+	}
+	if w := serve(a, auth.ScopeRead, tokenRequest("new-token")); w.Code != http.StatusOK {
+		t.Errorf("new token status = %d, want 200", w.Code)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	a := auth.NewAuthenticator(testConfig())
+
+	req := newSigned("valid")
+	w := serve(a, auth.ScopeStart, req.build())
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200, body %s", w.Code, w.Body.String())
+	}
+	if got := w.Body.String(); got != string(req.body) {
+		t.Errorf("handler read body %q, want %q", got, req.body)
+	}
+
+	tampered := newSigned("tampered").build()
+	tampered.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"envs":{"A":"2"}}`)))
+
+	cases := []struct {
+		name  string
+		req   *http.Request
+		scope auth.Scope
+		want  int
+	}{
+		{
+			name: "wrong secret",
+			req:  signedRequest{keyID: testKeyID, secret: "other", ts: time.Now(), nonce: "n1", body: nil}.build(),
+			want: http.StatusUnauthorized,
+		},
+		{name: "tampered body", req: tampered, want: http.StatusUnauthorized},
+		{
+			name: "unknown key id",
+			req:  signedRequest{keyID: "k2", secret: testSecret, ts: time.Now(), nonce: "n2"}.build(),
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp too old",
+			req:  signedRequest{keyID: testKeyID, secret: testSecret, ts: time.Now().Add(-10 * time.Minute), nonce: "n3"}.build(),
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp in the future",
+			req:  signedRequest{keyID: testKeyID, secret: testSecret, ts: time.Now().Add(10 * time.Minute), nonce: "n4"}.build(),
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "missing nonce",
+			req:  signedRequest{keyID: testKeyID, secret: testSecret, ts: time.Now()}.build(),
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:  "missing scope",
+			req:   newSigned("n5").build(),
+			scope: auth.ScopeExec,
+			want:  http.StatusForbidden,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			scope := c.scope
+			if scope == "" {
+				scope = auth.ScopeStart
+			}
+			w := serve(a, scope, c.req)
+			if w.Code != c.want {
+				t.Fatalf("status = %d, want %d, body %s", w.Code, c.want, w.Body.String())
+			}
+			errorDetails(t, w)
+		})
+	}
+}
+
+func TestHMACClockSkewConfig(t *testing.T) {
+	conf := testConfig()
+	conf.MaxClockSkew = "1h"
+	a := auth.NewAuthenticator(conf)
+
+	req := newSigned("skewed")
+	req.ts = time.Now().Add(-30 * time.Minute)
+	if w := serve(a, auth.ScopeStart, req.build()); w.Code != http.StatusOK {
+		t.Fatalf("status = %d within max_clock_skew, want 200, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHMACNonceReplay(t *testing.T) {
+	a := auth.NewAuthenticator(testConfig())
+
+	req := newSigned("once")
+	if w := serve(a, auth.ScopeStart, req.build()); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", w.Code)
+	}
+	w := serve(a, auth.ScopeStart, req.build())
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed request status = %d, want 401", w.Code)
+	}
+	if details := errorDetails(t, w); details == "" {
+		t.Errorf("replayed request without details")
+	}
+
+	// 签名不对的请求不会占用 nonce
+	bad := newSigned("reserved")
+	bad.secret = "other"
+	serve(a, auth.ScopeStart, bad.build())
+	if w := serve(a, auth.ScopeStart, newSigned("reserved").build()); w.Code != http.StatusOK {
+		t.Errorf("nonce used by a forged request: status = %d, want 200", w.Code)
+	}
+}
+
+func TestHMACBodyTooLarge(t *testing.T) {
+	a := auth.NewAuthenticator(testConfig())
+
+	req := newSigned("large")
+	req.body = make([]byte, 32<<20+1)
+	w := serve(a, auth.ScopeStart, req.build())
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+	errorDetails(t, w)
+}
@@ -0,0 +1,637 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/auth"
+	"github.com/zexi/wolf-hook/pkg/envfile"
+	"github.com/zexi/wolf-hook/pkg/listener"
+	"github.com/zexi/wolf-hook/pkg/ownership"
+	"github.com/zexi/wolf-hook/pkg/probe"
+	"github.com/zexi/wolf-hook/pkg/redact"
+	"github.com/zexi/wolf-hook/pkg/resources"
+)
+
+const (
+	DefaultEntrypoint  = "/entrypoint.sh"
+	DefaultEnvFilePath = "/opt/bin/hook-env.sh"
+	DefaultListenAddr  = "tcp://127.0.0.1:8080"
+)
+
+// Duration 支持 "10s" 这样的字符串或者以秒为单位的数字，定义在 api 中以便在请求参数中使用
+type Duration = api.Duration
+
+// AuthConfig 除了文件中内联的凭据外，还可以单独指定拥有全部权限的 token 和 HMAC secret
+type AuthConfig struct {
+	Token      string `json:"token,omitempty"`
+	HMACSecret string `json:"hmac_secret,omitempty"`
+	// File 是额外的 JSON 凭据文件
+	File string `json:"file,omitempty"`
+	auth.Config
+}
+
+// EntrypointConfig 是默认的应用入口，/hook/start 的参数可以覆盖其中的字段
+type EntrypointConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	WorkDir string   `json:"workdir,omitempty"`
+	// User 是运行应用的用户名或 uid，从 /etc/passwd 和 /etc/group 查找 uid、gid 和附加组，
+	// 并设置 HOME 等环境变量，不能和 UID、GID 同时指定
+	User string `json:"user,omitempty"`
+	UID  *int   `json:"uid,omitempty"`
+	GID  *int   `json:"gid,omitempty"`
+	// Umask 是八进制字符串，为空时继承 wolf-hook 的 umask
+	Umask string `json:"umask,omitempty"`
+	// AllowedCommands 是除 Command 外允许通过 /hook/start 指定的命令
+	AllowedCommands []string `json:"allowed_commands,omitempty"`
+	// AllowUserOverride 为 true 时允许通过 /hook/start 指定 user、uid 和 gid
+	AllowUserOverride bool `json:"allow_user_override,omitempty"`
+}
+
+// ResourcesConfig 控制应用的资源限制
+type ResourcesConfig struct {
+	// Rlimits 的键是 nofile、memlock、nproc 或 core，在应用 exec 前设置
+	Rlimits map[string]resources.Rlimit `json:"rlimits,omitempty"`
+	Cgroup  CgroupConfig                `json:"cgroup"`
+	// AllowOverride 为 true 时允许通过 /hook/start 指定 rlimits 和 cgroup 限制
+	AllowOverride bool `json:"allow_override,omitempty"`
+}
+
+// CgroupConfig 控制是否把应用放到 cgroup v2 的子组中，cgroup v2 不可写时只记录警告
+type CgroupConfig struct {
+	Enabled bool `json:"enabled"`
+	// Root 是 cgroup v2 的挂载点
+	Root string `json:"root"`
+	// Name 是在 wolf-hook 所在 cgroup 下创建的子组名称
+	Name string `json:"name"`
+	resources.CgroupLimits
+}
+
+// EnvFileConfig 控制启动应用时写入的环境变量文件
+type EnvFileConfig struct {
+	Path string `json:"path"`
+	// Format 是 shell、dotenv、json 或 systemd
+	Format string `json:"format"`
+	// Mode 是八进制的文件权限
+	Mode string `json:"mode"`
+	envfile.Filter
+}
+
+// OwnershipConfig 是启动前修改属主和权限的规则
+type OwnershipConfig struct {
+	// Rules 为 null 时使用 ownership.DefaultRules，为空列表时不修改任何路径
+	Rules []ownership.Rule `json:"rules"`
+	// DryRun 为 true 时只记录需要的修改而不执行
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// EffectiveRules 返回实际执行的规则
+func (c OwnershipConfig) EffectiveRules() []ownership.Rule {
+	if c.Rules == nil {
+		return ownership.DefaultRules()
+	}
+	return c.Rules
+}
+
+// StepConfig 是一个启动前准备步骤的配置
+type StepConfig struct {
+	Name string `json:"name"`
+	// Enabled 为空时启用
+	Enabled *bool `json:"enabled,omitempty"`
+	// Timeout 为 0 时不限制
+	Timeout           Duration `json:"timeout,omitempty"`
+	ContinueOnFailure bool     `json:"continue_on_failure,omitempty"`
+}
+
+// UnmarshalJSON 每次都从零值开始解析，避免 LoadFile 把文件中的列表元素合并到默认值上
+func (s *StepConfig) UnmarshalJSON(b []byte) error {
+	type plain StepConfig
+	v := plain{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = StepConfig(v)
+	return nil
+}
+
+func (s StepConfig) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// PrestartConfig 是应用启动前按顺序执行的步骤
+type PrestartConfig struct {
+	Steps []StepConfig `json:"steps"`
+}
+
+// ReadinessConfig 控制应用启动后的就绪检查，所有探测都成功后进入 READY 状态
+type ReadinessConfig struct {
+	// Probes 为空时应用进入 RUNNING 后立即就绪
+	Probes   []probe.Config `json:"probes,omitempty"`
+	Interval Duration       `json:"interval"`
+	// Timeout 内没有就绪时进入 FAILED 状态
+	Timeout Duration `json:"timeout"`
+}
+
+// 看门狗连续失败后的动作
+const (
+	WatchdogActionExit       = "exit"
+	WatchdogActionRestart    = "restart"
+	WatchdogActionMarkFailed = "mark_failed"
+	WatchdogActionHook       = "hook"
+)
+
+// WatchdogConfig 控制应用启动后的存活检查
+type WatchdogConfig struct {
+	Enabled bool `json:"enabled"`
+	// ProcessName 兼容旧配置，不为空时等价于 probe: {type: process_name, target: <process_name>}
+	ProcessName string       `json:"process_name,omitempty"`
+	Probe       probe.Config `json:"probe"`
+	// InitialDelay 是入口进程退出后开始检查前的等待时间
+	InitialDelay     Duration `json:"initial_delay,omitempty"`
+	Interval         Duration `json:"interval"`
+	FailureThreshold int      `json:"failure_threshold"`
+	// Action 是 exit、restart、mark_failed 或 hook，mark_failed 把运行标记为失败后按 restart.policy 处理
+	Action string `json:"action"`
+	// ExitDelay 和 ExitCode 用于 exit 动作
+	ExitDelay Duration `json:"exit_delay"`
+	ExitCode  int      `json:"exit_code"`
+	// HookCommand 用于 hook 动作，执行后继续检查
+	HookCommand []string `json:"hook_command,omitempty"`
+}
+
+// ProbeConfig 返回实际使用的探测配置
+func (w WatchdogConfig) ProbeConfig() probe.Config {
+	if w.ProcessName != "" {
+		return probe.Config{Type: probe.TypeProcessName, Target: w.ProcessName}
+	}
+	return w.Probe
+}
+
+// 应用退出后的重启策略
+const (
+	RestartPolicyNo        = "no"
+	RestartPolicyOnFailure = "on-failure"
+	RestartPolicyAlways    = "always"
+)
+
+// RestartConfig 控制应用入口进程退出后是否自动重启，语义与 systemd 的 Restart= 类似
+type RestartConfig struct {
+	// Policy 是 no、on-failure 或 always
+	Policy string `json:"policy"`
+	// 第 n 次重启前等待 InitialBackoff * 2^(n-1)，最多 MaxBackoff
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	// Window 内重启次数达到 MaxRestarts 后不再重启，MaxRestarts 为 0 时不限制
+	MaxRestarts int      `json:"max_restarts"`
+	Window      Duration `json:"window"`
+}
+
+// LogConfig 控制 wolf-hook 自身的日志
+type LogConfig struct {
+	// Level 是 debug、info、warning 或 error
+	Level string `json:"level"`
+	// Verbose 大于等于 2 时记录 moonlight 输入数据包等大量的调试日志
+	Verbose int `json:"verbose"`
+	// Redact 控制日志中需要隐藏值的环境变量和参数
+	Redact redact.Config `json:"redact"`
+}
+
+// LogsConfig 控制应用输出的保存
+type LogsConfig struct {
+	// BufferLines 是内存中保留的行数
+	BufferLines int `json:"buffer_lines"`
+	// File 不为空时同时写入该文件，超过 MaxSizeMB 后轮转
+	File       string `json:"file,omitempty"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+}
+
+type MoonlightConfig struct {
+	AutoStart bool   `json:"auto_start"`
+	Host      string `json:"host"`
+	HTTPPort  int    `json:"http_port"`
+	ClientID  string `json:"client_id"`
+}
+
+type ShutdownConfig struct {
+	// Init 为空时在 pid 为 1 时启用
+	Init        *bool    `json:"init,omitempty"`
+	GracePeriod Duration `json:"grace_period"`
+	// ChildSubreaper 为 true 时不是 1 号进程也收养并回收应用的孤儿进程
+	ChildSubreaper bool `json:"child_subreaper"`
+}
+
+// PoliciesConfig 限制可以通过 API 执行的操作
+type PoliciesConfig struct {
+	AllowExec bool `json:"allow_exec"`
+	// WriteHwdbDirs 不为空时 /hook/write-hwdb 只允许写入这些目录
+	WriteHwdbDirs []string `json:"write_hwdb_dirs,omitempty"`
+}
+
+type Config struct {
+	Listen           []string         `json:"listen"`
+	UnixSocketMode   string           `json:"unix_socket_mode"`
+	UnixSocketOwner  string           `json:"unix_socket_owner,omitempty"`
+	UlimitNofileHard int              `json:"ulimit_nofile_hard"`
+	UlimitNofileSoft int              `json:"ulimit_nofile_soft"`
+	Auth             AuthConfig       `json:"auth"`
+	Entrypoint       EntrypointConfig `json:"entrypoint"`
+	Resources        ResourcesConfig  `json:"resources"`
+	EnvFile          EnvFileConfig    `json:"env_file"`
+	Ownership        OwnershipConfig  `json:"ownership"`
+	Prestart         PrestartConfig   `json:"prestart"`
+	Readiness        ReadinessConfig  `json:"readiness"`
+	Watchdog         WatchdogConfig   `json:"watchdog"`
+	Restart          RestartConfig    `json:"restart"`
+	Log              LogConfig        `json:"log"`
+	Logs             LogsConfig       `json:"logs"`
+	Moonlight        MoonlightConfig  `json:"moonlight"`
+	Shutdown         ShutdownConfig   `json:"shutdown"`
+	Policies         PoliciesConfig   `json:"policies"`
+}
+
+// Default 返回与之前硬编码行为一致的默认配置
+func Default() *Config {
+	return &Config{
+		Listen:           []string{DefaultListenAddr},
+		UnixSocketMode:   "0660",
+		UlimitNofileHard: 10240,
+		UlimitNofileSoft: 10240,
+		Entrypoint: EntrypointConfig{
+			Command: DefaultEntrypoint,
+		},
+		EnvFile: EnvFileConfig{
+			Path:   DefaultEnvFilePath,
+			Format: string(envfile.FormatShell),
+			Mode:   "0644",
+		},
+		Ownership: OwnershipConfig{
+			Rules: ownership.DefaultRules(),
+		},
+		// 与之前硬编码的顺序一致，写环境变量文件失败不影响启动
+		Prestart: PrestartConfig{
+			Steps: []StepConfig{
+				{Name: "udev-control"},
+				{Name: "ownership"},
+				{Name: "env-file", ContinueOnFailure: true},
+			},
+		},
+		Resources: ResourcesConfig{
+			Cgroup: CgroupConfig{
+				Root: "/sys/fs/cgroup",
+				Name: "wolf-app",
+			},
+		},
+		Readiness: ReadinessConfig{
+			Interval: Duration(500 * time.Millisecond),
+			Timeout:  Duration(api.DefaultReadinessTimeout),
+		},
+		Watchdog: WatchdogConfig{
+			Enabled:          true,
+			Probe:            probe.Config{Type: probe.TypeProcessName, Target: "sway"},
+			Interval:         Duration(3 * time.Second),
+			FailureThreshold: 1,
+			Action:           WatchdogActionExit,
+			ExitDelay:        Duration(2 * time.Second),
+			ExitCode:         134,
+		},
+		Restart: RestartConfig{
+			Policy:         RestartPolicyNo,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(30 * time.Second),
+			MaxRestarts:    5,
+			Window:         Duration(5 * time.Minute),
+		},
+		Log: LogConfig{
+			Level: "debug",
+			Redact: redact.Config{
+				Patterns: append([]string(nil), redact.DefaultPatterns...),
+			},
+		},
+		Logs: LogsConfig{
+			BufferLines: 5000,
+			MaxSizeMB:   10,
+			MaxBackups:  3,
+		},
+		Moonlight: MoonlightConfig{
+			Host:     "127.0.0.1",
+			HTTPPort: 20008,
+			ClientID: "go_client_001",
+		},
+		Shutdown: ShutdownConfig{
+			GracePeriod: Duration(10 * time.Second),
+		},
+		Policies: PoliciesConfig{
+			AllowExec: true,
+		},
+	}
+}
+
+// LoadFile 把 YAML 或 JSON 配置文件合并到 conf 中，未出现的字段保持原值，
+// 不认识的字段会报错
+func LoadFile(path string, conf *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", path)
+	}
+	// YAML 是 JSON 的超集，统一转换为 JSON 后按 json tag 解析
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return errors.Wrapf(err, "parse %s", path)
+	}
+	if raw == nil {
+		return nil
+	}
+	jsonData, err := json.Marshal(raw)
+	if err != nil {
+		return errors.Wrapf(err, "convert %s to json", path)
+	}
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(conf); err != nil {
+		return errors.Wrapf(err, "decode %s", path)
+	}
+	return nil
+}
+
+// ApplyEnv 用环境变量覆盖配置
+func ApplyEnv(conf *Config) {
+	if v := os.Getenv("WOLF_HTTP_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			conf.Moonlight.HTTPPort = port
+		}
+	}
+	if v := os.Getenv("WOLF_CLIENT_ID"); v != "" {
+		conf.Moonlight.ClientID = v
+	}
+	if v := os.Getenv("WOLF_HOOK_LOG_LEVEL"); v != "" {
+		conf.Log.Level = v
+	}
+	if v := os.Getenv("WOLF_HOOK_RESTART_POLICY"); v != "" {
+		conf.Restart.Policy = v
+	}
+	if v := os.Getenv("WOLF_HOOK_CHILD_SUBREAPER"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			conf.Shutdown.ChildSubreaper = b
+		}
+	}
+	if v := os.Getenv("WOLF_HOOK_AUTH_TOKEN"); v != "" {
+		conf.Auth.Token = v
+	}
+	if v := os.Getenv("WOLF_HOOK_AUTH_HMAC_SECRET"); v != "" {
+		conf.Auth.HMACSecret = v
+	}
+	if v := os.Getenv("WOLF_HOOK_AUTH_FILE"); v != "" {
+		conf.Auth.File = v
+	}
+}
+
+// BuildAuthConfig 合并凭据文件和单独指定的凭据，生成最终的鉴权配置
+func (c *Config) BuildAuthConfig() (*auth.Config, error) {
+	return auth.MergeCredentials(&c.Auth.Config, c.Auth.Token, c.Auth.HMACSecret, c.Auth.File)
+}
+
+// InitMode 返回是否以 init 进程的方式处理信号
+func (c *Config) InitMode() bool {
+	if c.Shutdown.Init != nil {
+		return *c.Shutdown.Init
+	}
+	return os.Getpid() == 1
+}
+
+func (c *Config) Validate() error {
+	if len(c.Listen) == 0 {
+		return errors.Errorf("listen: at least one address is required")
+	}
+	for _, l := range c.Listen {
+		if _, err := listener.ParseAddress(l); err != nil {
+			return errors.Wrap(err, "listen")
+		}
+	}
+	if _, err := strconv.ParseUint(c.UnixSocketMode, 8, 32); err != nil {
+		return errors.Errorf("unix_socket_mode: invalid octal mode %q", c.UnixSocketMode)
+	}
+	if _, _, err := listener.ParseOwner(c.UnixSocketOwner); err != nil {
+		return errors.Wrap(err, "unix_socket_owner")
+	}
+	if c.UlimitNofileSoft > c.UlimitNofileHard {
+		return errors.Errorf("ulimit_nofile_soft %d is greater than ulimit_nofile_hard %d", c.UlimitNofileSoft, c.UlimitNofileHard)
+	}
+	if _, err := c.BuildAuthConfig(); err != nil {
+		return errors.Wrap(err, "auth")
+	}
+	if !filepath.IsAbs(c.Entrypoint.Command) {
+		return errors.Errorf("entrypoint.command must be an absolute path: %q", c.Entrypoint.Command)
+	}
+	for i, cmd := range c.Entrypoint.AllowedCommands {
+		if !filepath.IsAbs(cmd) {
+			return errors.Errorf("entrypoint.allowed_commands[%d] must be an absolute path: %q", i, cmd)
+		}
+	}
+	if c.Entrypoint.WorkDir != "" && !filepath.IsAbs(c.Entrypoint.WorkDir) {
+		return errors.Errorf("entrypoint.workdir must be an absolute path: %q", c.Entrypoint.WorkDir)
+	}
+	if (c.Entrypoint.UID != nil && *c.Entrypoint.UID < 0) || (c.Entrypoint.GID != nil && *c.Entrypoint.GID < 0) {
+		return errors.Errorf("entrypoint: invalid uid/gid")
+	}
+	if c.Entrypoint.User != "" && (c.Entrypoint.UID != nil || c.Entrypoint.GID != nil) {
+		return errors.Errorf("entrypoint.user can't be used with entrypoint.uid or entrypoint.gid")
+	}
+	if c.Entrypoint.Umask != "" {
+		if mask, err := strconv.ParseUint(c.Entrypoint.Umask, 8, 32); err != nil || mask > 0777 {
+			return errors.Errorf("entrypoint.umask: invalid octal mask %q", c.Entrypoint.Umask)
+		}
+	}
+	if !filepath.IsAbs(c.EnvFile.Path) {
+		return errors.Errorf("env_file.path must be an absolute path: %q", c.EnvFile.Path)
+	}
+	if _, err := envfile.ParseFormat(c.EnvFile.Format); err != nil {
+		return errors.Wrap(err, "env_file.format")
+	}
+	if _, err := strconv.ParseUint(c.EnvFile.Mode, 8, 32); err != nil {
+		return errors.Errorf("env_file.mode: invalid octal mode %q", c.EnvFile.Mode)
+	}
+	if err := c.EnvFile.Filter.Validate(); err != nil {
+		return errors.Wrap(err, "env_file")
+	}
+	ruleNames := make(map[string]bool, len(c.Ownership.Rules))
+	for i, r := range c.Ownership.Rules {
+		if err := r.Validate(); err != nil {
+			return errors.Wrapf(err, "ownership.rules[%d]", i)
+		}
+		if r.Name != "" {
+			if ruleNames[r.Name] {
+				return errors.Errorf("ownership.rules[%d]: duplicate name %q", i, r.Name)
+			}
+			ruleNames[r.Name] = true
+		}
+	}
+	for i, s := range c.Prestart.Steps {
+		if s.Name == "" {
+			return errors.Errorf("prestart.steps[%d]: name is required", i)
+		}
+		if s.Timeout < 0 {
+			return errors.Errorf("prestart.steps[%d]: timeout must not be negative", i)
+		}
+	}
+	if err := resources.ValidateRlimits(c.Resources.Rlimits); err != nil {
+		return errors.Wrap(err, "resources.rlimits")
+	}
+	if c.Resources.Cgroup.Enabled {
+		if !filepath.IsAbs(c.Resources.Cgroup.Root) {
+			return errors.Errorf("resources.cgroup.root must be an absolute path: %q", c.Resources.Cgroup.Root)
+		}
+		if c.Resources.Cgroup.Name == "" || strings.Contains(c.Resources.Cgroup.Name, "/") || strings.HasPrefix(c.Resources.Cgroup.Name, ".") {
+			return errors.Errorf("resources.cgroup.name %q must be a single path component", c.Resources.Cgroup.Name)
+		}
+	}
+	if err := c.Resources.Cgroup.Validate(); err != nil {
+		return errors.Wrap(err, "resources.cgroup")
+	}
+	for i, p := range c.Readiness.Probes {
+		if _, err := probe.New(p); err != nil {
+			return errors.Wrapf(err, "readiness.probes[%d]", i)
+		}
+	}
+	if c.Readiness.Interval <= 0 || c.Readiness.Timeout <= 0 {
+		return errors.Errorf("readiness.interval and readiness.timeout must be positive")
+	}
+	if c.Watchdog.Enabled {
+		if _, err := probe.New(c.Watchdog.ProbeConfig()); err != nil {
+			return errors.Wrap(err, "watchdog.probe")
+		}
+		if c.Watchdog.Interval <= 0 {
+			return errors.Errorf("watchdog.interval must be positive")
+		}
+		if c.Watchdog.FailureThreshold <= 0 {
+			return errors.Errorf("watchdog.failure_threshold must be positive")
+		}
+		switch c.Watchdog.Action {
+		case WatchdogActionExit, WatchdogActionRestart, WatchdogActionMarkFailed:
+		case WatchdogActionHook:
+			if len(c.Watchdog.HookCommand) == 0 || !filepath.IsAbs(c.Watchdog.HookCommand[0]) {
+				return errors.Errorf("watchdog.hook_command must start with an absolute path")
+			}
+		default:
+			return errors.Errorf("watchdog.action: unknown action %q", c.Watchdog.Action)
+		}
+	}
+	switch c.Restart.Policy {
+	case RestartPolicyNo, RestartPolicyOnFailure, RestartPolicyAlways:
+	default:
+		return errors.Errorf("restart.policy: unknown policy %q", c.Restart.Policy)
+	}
+	if c.Restart.InitialBackoff <= 0 || c.Restart.MaxBackoff < c.Restart.InitialBackoff {
+		return errors.Errorf("restart.initial_backoff must be positive and not greater than restart.max_backoff")
+	}
+	if c.Restart.MaxRestarts < 0 {
+		return errors.Errorf("restart.max_restarts must not be negative")
+	}
+	if c.Restart.MaxRestarts > 0 && c.Restart.Window <= 0 {
+		return errors.Errorf("restart.window must be positive when restart.max_restarts is set")
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warning", "error":
+	default:
+		return errors.Errorf("log.level: unknown level %q", c.Log.Level)
+	}
+	if c.Log.Verbose < 0 {
+		return errors.Errorf("log.verbose must not be negative")
+	}
+	if err := c.Log.Redact.Validate(); err != nil {
+		return errors.Wrap(err, "log.redact")
+	}
+	if c.Logs.BufferLines <= 0 {
+		return errors.Errorf("logs.buffer_lines must be positive")
+	}
+	if c.Logs.File != "" && !filepath.IsAbs(c.Logs.File) {
+		return errors.Errorf("logs.file must be an absolute path: %q", c.Logs.File)
+	}
+	if c.Logs.MaxSizeMB < 0 || c.Logs.MaxBackups < 0 {
+		return errors.Errorf("logs.max_size_mb and logs.max_backups must not be negative")
+	}
+	if c.Moonlight.HTTPPort <= 0 || c.Moonlight.HTTPPort > 65535 {
+		return errors.Errorf("moonlight.http_port %d out of range", c.Moonlight.HTTPPort)
+	}
+	if c.Shutdown.GracePeriod < 0 {
+		return errors.Errorf("shutdown.grace_period must not be negative")
+	}
+	for i, dir := range c.Policies.WriteHwdbDirs {
+		if !filepath.IsAbs(dir) {
+			return errors.Errorf("policies.write_hwdb_dirs[%d] must be an absolute path: %q", i, dir)
+		}
+	}
+	return nil
+}
+
+// RuntimeChanges 返回 newConf 中修改了但需要重启才能生效的配置项
+func RuntimeChanges(oldConf, newConf *Config) []string {
+	var changed []string
+	if strings.Join(oldConf.Listen, ",") != strings.Join(newConf.Listen, ",") {
+		changed = append(changed, "listen")
+	}
+	if oldConf.UnixSocketMode != newConf.UnixSocketMode || oldConf.UnixSocketOwner != newConf.UnixSocketOwner {
+		changed = append(changed, "unix_socket_mode/unix_socket_owner")
+	}
+	if oldConf.UlimitNofileHard != newConf.UlimitNofileHard || oldConf.UlimitNofileSoft != newConf.UlimitNofileSoft {
+		changed = append(changed, "ulimit_nofile_hard/ulimit_nofile_soft")
+	}
+	if oldConf.Logs.BufferLines != newConf.Logs.BufferLines {
+		changed = append(changed, "logs.buffer_lines")
+	}
+	if oldConf.Moonlight != newConf.Moonlight {
+		changed = append(changed, "moonlight")
+	}
+	if oldConf.InitMode() != newConf.InitMode() {
+		changed = append(changed, "shutdown.init")
+	}
+	if oldConf.Shutdown.ChildSubreaper != newConf.Shutdown.ChildSubreaper {
+		changed = append(changed, "shutdown.child_subreaper")
+	}
+	return changed
+}
+
+// KeepStatic 把需要重启才能生效的配置项恢复为 oldConf 中的值
+func KeepStatic(oldConf, newConf *Config) {
+	newConf.Listen = oldConf.Listen
+	newConf.UnixSocketMode = oldConf.UnixSocketMode
+	newConf.UnixSocketOwner = oldConf.UnixSocketOwner
+	newConf.UlimitNofileHard = oldConf.UlimitNofileHard
+	newConf.UlimitNofileSoft = oldConf.UlimitNofileSoft
+	newConf.Logs.BufferLines = oldConf.Logs.BufferLines
+	newConf.Moonlight = oldConf.Moonlight
+	newConf.Shutdown.Init = oldConf.Shutdown.Init
+	newConf.Shutdown.ChildSubreaper = oldConf.Shutdown.ChildSubreaper
+}
+
+var (
+	current     = Default()
+	currentLock sync.RWMutex
+)
+
+// Get 返回当前生效的配置，调用方不能修改返回值
+func Get() *Config {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+
+	return current
+}
+
+func Set(conf *Config) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	current = conf
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch 定期检查配置文件的修改时间和大小，发生变化时调用 onChange
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	lastMod, lastSize := stat()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		mod, size := stat()
+		if size < 0 {
+			// 文件被删除或者正在被替换，等待下一次检查
+			continue
+		}
+		if !mod.Equal(lastMod) || size != lastSize {
+			lastMod, lastSize = mod, size
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package envfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// Format 是环境变量文件的格式
+type Format string
+
+const (
+	// FormatShell 是可以被 sh source 的 export K='V'
+	FormatShell Format = "shell"
+	// FormatDotenv 是 K="V"，双引号内转义 \ " $ ` 和换行
+	FormatDotenv Format = "dotenv"
+	// FormatJSON 是按 key 排序的 JSON 对象
+	FormatJSON Format = "json"
+	// FormatSystemd 是 systemd 的 EnvironmentFile
+	FormatSystemd Format = "systemd"
+)
+
+var Formats = []Format{FormatShell, FormatDotenv, FormatJSON, FormatSystemd}
+
+func ParseFormat(s string) (Format, error) {
+	if s == "" {
+		return FormatShell, nil
+	}
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", errors.Errorf("unknown env file format %q", s)
+}
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidName 返回是否是 shell 可以使用的变量名
+func ValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+// Filter 选择需要写入的变量，Include 为空时包含所有变量；
+// 规则是 path.Match 风格的通配符，例如 "STEAM_*"
+type Filter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) Match(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func (f Filter) Validate() error {
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// FromEnviron 把 KEY=VALUE 列表转换为 map，重复的 key 以后出现的为准
+func FromEnviron(environ []string) map[string]string {
+	vars := make(map[string]string, len(environ))
+	for _, e := range environ {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		vars[pair[0]] = pair[1]
+	}
+	return vars
+}
+
+// Render 按格式渲染通过 filter 的变量，返回内容和因为变量名不合法而跳过的变量
+func Render(vars map[string]string, format Format, filter Filter) ([]byte, []string, error) {
+	var names, skipped []string
+	for name := range vars {
+		if !filter.Match(name) {
+			continue
+		}
+		// JSON 可以表示任意 key，其余格式要求合法的变量名
+		if format != FormatJSON && !ValidName(name) {
+			skipped = append(skipped, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.Strings(skipped)
+
+	buf := new(bytes.Buffer)
+	switch format {
+	case FormatShell:
+		for _, name := range names {
+			fmt.Fprintf(buf, "export %s=%s\n", name, ShellQuote(vars[name]))
+		}
+	case FormatDotenv:
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s=%s\n", name, dotenvQuote(vars[name]))
+		}
+	case FormatSystemd:
+		for _, name := range names {
+			fmt.Fprintf(buf, "%s=%s\n", name, systemdQuote(vars[name]))
+		}
+	case FormatJSON:
+		selected := make(map[string]string, len(names))
+		for _, name := range names {
+			selected[name] = vars[name]
+		}
+		data, err := json.MarshalIndent(selected, "", "  ")
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "marshal env")
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	default:
+		return nil, nil, errors.Errorf("unknown env file format %q", format)
+	}
+	return buf.Bytes(), skipped, nil
+}
+
+// ShellQuote 用单引号包裹值，值中的单引号通过结束引号、转义单引号、重新开始引号来表示，
+// 结果可以安全地被 POSIX shell 解析
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func dotenvQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// systemdQuote 双引号内只有 \ " $ ` 需要转义，换行可以直接出现在引号内
+func systemdQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(s) + `"`
+}
+
+// WriteFile 先写入同目录下的临时文件再 rename，读取方不会看到写了一半的文件
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "create dir %s", dir)
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create temp file in %s", dir)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "write %s", tmpName)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "chmod %s", tmpName)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "sync %s", tmpName)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", tmpName)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return errors.Wrapf(err, "rename %s to %s", tmpName, path)
+	}
+	return nil
+}
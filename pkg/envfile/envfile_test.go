@@ -0,0 +1,163 @@
+package envfile_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zexi/wolf-hook/pkg/envfile"
+)
+
+// trickyVars 是需要转义才能安全写入的值，以及变量名不合法的变量
+var trickyVars = map[string]string{
+	"PLAIN":     "steam",
+	"SINGLE":    "it's",
+	"DOUBLE":    `say "hi"`,
+	"DOLLAR":    "$HOME ${PATH} $(id)",
+	"BACKTICK":  "`id`",
+	"BACKSLASH": `C:\path\n`,
+	"NEWLINE":   "line1\nline2",
+	"PERCENT":   "%h %% 100%",
+	"EMPTY":     "",
+	"BAD-KEY":   "x",
+	"1ABC":      "y",
+}
+
+func render(t *testing.T, vars map[string]string, format envfile.Format) (string, []string) {
+	t.Helper()
+
+	data, skipped, err := envfile.Render(vars, format, envfile.Filter{})
+	if err != nil {
+		t.Fatalf("render %s: %v", format, err)
+	}
+	return string(data), skipped
+}
+
+func TestRenderShell(t *testing.T) {
+	got, skipped := render(t, trickyVars, envfile.FormatShell)
+	want := `export BACKSLASH='C:\path\n'
+export BACKTICK='` + "`id`" + `'
+export DOLLAR='$HOME ${PATH} $(id)'
+export DOUBLE='say "hi"'
+export EMPTY=''
+export NEWLINE='line1
+line2'
+export PERCENT='%h %% 100%'
+export PLAIN='steam'
+export SINGLE='it'\''s'
+`
+	if got != want {
+		t.Errorf("shell output:\n%s\nwant:\n%s", got, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"1ABC", "BAD-KEY"}) {
+		t.Errorf("skipped = %v, want invalid names", skipped)
+	}
+}
+
+// TestRenderShellRoundTrip 检查 source 生成的文件后得到的值和原来的值完全一致，没有被展开或者执行
+func TestRenderShellRoundTrip(t *testing.T) {
+	got, _ := render(t, trickyVars, envfile.FormatShell)
+	path := filepath.Join(t.TempDir(), "hook-env.sh")
+	if err := envfile.WriteFile(path, []byte(got), 0644); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", `set -a; . "$0"; exec env -0`, path)
+	cmd.Env = []string{"PATH=/usr/bin:/bin", "HOME=/nonexistent"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("source env file: %v", err)
+	}
+	env := make(map[string]string)
+	for _, e := range bytes.Split(bytes.TrimRight(out, "\x00"), []byte{0}) {
+		pair := strings.SplitN(string(e), "=", 2)
+		if len(pair) == 2 {
+			env[pair[0]] = pair[1]
+		}
+	}
+	for name, want := range trickyVars {
+		if !envfile.ValidName(name) {
+			continue
+		}
+		if v, ok := env[name]; !ok || v != want {
+			t.Errorf("%s after source = %q (set %v), want %q", name, v, ok, want)
+		}
+	}
+}
+
+func TestRenderDotenv(t *testing.T) {
+	got, skipped := render(t, trickyVars, envfile.FormatDotenv)
+	want := `BACKSLASH="C:\\path\\n"
+BACKTICK="` + "\\`id\\`" + `"
+DOLLAR="\$HOME \${PATH} \$(id)"
+DOUBLE="say \"hi\""
+EMPTY=""
+NEWLINE="line1\nline2"
+PERCENT="%h %% 100%"
+PLAIN="steam"
+SINGLE="it's"
+`
+	if got != want {
+		t.Errorf("dotenv output:\n%s\nwant:\n%s", got, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"1ABC", "BAD-KEY"}) {
+		t.Errorf("skipped = %v, want invalid names", skipped)
+	}
+}
+
+func TestRenderSystemd(t *testing.T) {
+	got, skipped := render(t, trickyVars, envfile.FormatSystemd)
+	// EnvironmentFile 不展开 % 说明符，% 原样写入；换行可以直接出现在双引号内
+	want := `BACKSLASH="C:\\path\\n"
+BACKTICK="` + "\\`id\\`" + `"
+DOLLAR="\$HOME \${PATH} \$(id)"
+DOUBLE="say \"hi\""
+EMPTY=""
+NEWLINE="line1
+line2"
+PERCENT="%h %% 100%"
+PLAIN="steam"
+SINGLE="it's"
+`
+	if got != want {
+		t.Errorf("systemd output:\n%s\nwant:\n%s", got, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"1ABC", "BAD-KEY"}) {
+		t.Errorf("skipped = %v, want invalid names", skipped)
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	got, skipped := render(t, trickyVars, envfile.FormatJSON)
+	if len(skipped) != 0 {
+		t.Errorf("skipped = %v, json keeps every name", skipped)
+	}
+	decoded := make(map[string]string)
+	if err := json.Unmarshal([]byte(got), &decoded); err != nil {
+		t.Fatalf("decode json output %q: %v", got, err)
+	}
+	if !reflect.DeepEqual(decoded, trickyVars) {
+		t.Errorf("json round trip = %v, want %v", decoded, trickyVars)
+	}
+	if !strings.HasPrefix(got, "{\n  \"1ABC\": \"y\",\n") {
+		t.Errorf("json output is not sorted and indented:\n%s", got)
+	}
+}
+
+func TestRenderFilter(t *testing.T) {
+	vars := map[string]string{"STEAM_A": "1", "STEAM_SECRET": "2", "OTHER": "3"}
+	data, _, err := envfile.Render(vars, envfile.FormatShell, envfile.Filter{
+		Include: []string{"STEAM_*"},
+		Exclude: []string{"*SECRET*"},
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if got, want := string(data), "export STEAM_A='1'\n"; got != want {
+		t.Errorf("filtered output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,235 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeStateChanged      Type = "state_changed"
+	TypeStepResult        Type = "step_result"
+	TypeProcessExit       Type = "process_exit"
+	TypeExecCompleted     Type = "exec_completed"
+	TypeFileWritten       Type = "file_written"
+	TypeWatchdogTriggered Type = "watchdog_triggered"
+	TypeSignalSent        Type = "signal_sent"
+	// TypeEventsLost 只在重放时生成，表示订阅方错过了已经不在缓冲区中的事件，
+	// 需要通过 /hook/status 重新同步状态
+	TypeEventsLost Type = "events_lost"
+)
+
+const (
+	DefaultBufferSize = 1024
+
+	subscriberQueueSize = 256
+)
+
+// Event 是推送给订阅方的生命周期事件，ID 单调递增
+type Event struct {
+	ID    uint64      `json:"id"`
+	Type  Type        `json:"type"`
+	Time  time.Time   `json:"time"`
+	RunID string      `json:"run_id,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Subscription 是一个事件订阅，订阅方处理过慢时 C 会被关闭，
+// 订阅方可以用最后收到的事件 ID 重新订阅
+type Subscription struct {
+	C   <-chan Event
+	ch  chan Event
+	bus *Bus
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus 广播事件并在环形缓冲区中保留最近的事件用于断线重放
+type Bus struct {
+	mu     sync.Mutex
+	nextID uint64
+	buf    []Event
+	start  int
+	size   int
+	subs   map[*Subscription]struct{}
+}
+
+func NewBus(size int) *Bus {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &Bus{
+		nextID: 1,
+		buf:    make([]Event, size),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish 发布事件并返回其 ID
+func (b *Bus) Publish(typ Type, runID string, data interface{}) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := Event{
+		ID:    b.nextID,
+		Type:  typ,
+		Time:  time.Now(),
+		RunID: runID,
+		Data:  data,
+	}
+	b.nextID++
+
+	if b.size < len(b.buf) {
+		b.buf[(b.start+b.size)%len(b.buf)] = ev
+		b.size++
+	} else {
+		b.buf[b.start] = ev
+		b.start = (b.start + 1) % len(b.buf)
+	}
+
+	for sub := range b.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			// 订阅方消费过慢，断开后由其重连重放
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+	return ev.ID
+}
+
+// Subscribe 订阅 lastID 之后的事件，返回缓冲区中需要重放的事件；
+// lastID 为 0 时不重放。lastID 之后的事件已经被覆盖，或者 lastID 不是本进程发布的事件时，
+// 重放的第一个事件为 events_lost
+func (b *Bus) Subscribe(lastID uint64) ([]Event, *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	if lastID > 0 {
+		if lost := b.lostLocked(lastID); lost != nil {
+			replay = append(replay, *lost)
+		}
+		for i := 0; i < b.size; i++ {
+			ev := b.buf[(b.start+i)%len(b.buf)]
+			if ev.ID > lastID {
+				replay = append(replay, ev)
+			}
+		}
+	}
+	ch := make(chan Event, subscriberQueueSize)
+	sub := &Subscription{C: ch, ch: ch, bus: b}
+	b.subs[sub] = struct{}{}
+	return replay, sub
+}
+
+// lostLocked 在 lastID 之后有事件无法重放时返回 events_lost 事件，调用方需要持有 mu。
+// 事件的 ID 是最后一个丢失的事件的 ID，订阅方用它重连时不会再次收到 events_lost
+func (b *Bus) lostLocked(lastID uint64) *Event {
+	latest := b.nextID - 1
+	data := EventsLostData{LastEventID: lastID}
+	var id uint64
+	switch {
+	case lastID > latest:
+		// wolf-hook 重启后事件 ID 从头开始
+		id = latest
+	case b.size > 0 && b.buf[b.start].ID > lastID+1:
+		data.FirstEventID = b.buf[b.start].ID
+		id = data.FirstEventID - 1
+	default:
+		return nil
+	}
+	return &Event{
+		ID:   id,
+		Type: TypeEventsLost,
+		Time: time.Now(),
+		Data: data,
+	}
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+var defaultBus = NewBus(DefaultBufferSize)
+
+func Default() *Bus {
+	return defaultBus
+}
+
+// Publish 向默认的事件总线发布事件
+func Publish(typ Type, runID string, data interface{}) uint64 {
+	return defaultBus.Publish(typ, runID, data)
+}
+
+// StateChangedData 是 state_changed 事件的内容
+type StateChangedData struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error,omitempty"`
+}
+
+// StepResultData 是 step_result 事件的内容
+type StepResultData struct {
+	Name       string `json:"name"`
+	Success    bool   `json:"success"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ProcessExitData 是 process_exit 事件的内容
+type ProcessExitData struct {
+	PID      int    `json:"pid"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+	// Orphan 为 true 表示进程不是应用入口进程，而是被 wolf-hook 收养的孤儿进程
+	Orphan bool `json:"orphan,omitempty"`
+}
+
+// ExecCompletedData 是 exec_completed 事件的内容
+type ExecCompletedData struct {
+	Cmd        string   `json:"cmd"`
+	Args       []string `json:"args,omitempty"`
+	ExitCode   int      `json:"exit_code"`
+	DurationMs int64    `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// FileWrittenData 是 file_written 事件的内容
+type FileWrittenData struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
+// WatchdogTriggeredData 是 watchdog_triggered 事件的内容
+type WatchdogTriggeredData struct {
+	Probe  string `json:"probe"`
+	Action string `json:"action"`
+	Error  string `json:"error"`
+}
+
+// SignalSentData 是 signal_sent 事件的内容
+type SignalSentData struct {
+	Signal string `json:"signal"`
+	Target string `json:"target"`
+	PIDs   []int  `json:"pids"`
+}
+
+// EventsLostData 是 events_lost 事件的内容
+type EventsLostData struct {
+	// LastEventID 是订阅方请求重放的起点
+	LastEventID uint64 `json:"last_event_id"`
+	// FirstEventID 是缓冲区中最早的事件，为 0 表示 LastEventID 不是当前 wolf-hook 进程发布的
+	FirstEventID uint64 `json:"first_event_id,omitempty"`
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/resources"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+// appProcess 记录通过 /hook/start 启动的应用入口进程，
+// 入口进程放在独立的进程组中，便于整体转发信号
+type appProcess struct {
+	pid      int
+	pgid     int
+	exited   bool
+	exitCode int
+	// exitedCh 在入口进程的退出码被记录后关闭
+	exitedCh chan struct{}
+	// home 是应用环境变量中的 HOME
+	home string
+	// cgroup 为空表示应用没有放到单独的 cgroup 中
+	cgroup *resources.Cgroup
+}
+
+var (
+	app     *appProcess
+	appLock sync.Mutex
+)
+
+func setAppProcess(pid int, home string) {
+	appLock.Lock()
+	defer appLock.Unlock()
+
+	app = &appProcess{pid: pid, pgid: pid, home: home, exitedCh: make(chan struct{})}
+}
+
+func setAppExited(code int) {
+	appLock.Lock()
+	defer appLock.Unlock()
+
+	if app != nil && !app.exited {
+		app.exited = true
+		app.exitCode = code
+		close(app.exitedCh)
+	}
+}
+
+func setAppCgroup(cg *resources.Cgroup) {
+	appLock.Lock()
+	defer appLock.Unlock()
+
+	if app != nil {
+		app.cgroup = cg
+	}
+}
+
+// appResourceUsage 返回应用所在 cgroup 的资源使用，应用不在单独的 cgroup 中时返回 nil
+func appResourceUsage() *resources.Usage {
+	appLock.Lock()
+	var cg *resources.Cgroup
+	if app != nil {
+		cg = app.cgroup
+	}
+	appLock.Unlock()
+
+	if cg == nil {
+		return nil
+	}
+	usage, err := cg.Usage()
+	if err != nil {
+		log.Warningf("read cgroup usage: %v", err)
+		return nil
+	}
+	return usage
+}
+
+// exitCodeOf 把进程退出状态转换为 shell 风格的退出码
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*procutils.ExitError); ok {
+		return waitStatusCode(exitErr.Status)
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return -1
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+		return waitStatusCode(ws)
+	}
+	return exitErr.ExitCode()
+}
+
+// waitStatusCode 把等待状态转换为 shell 风格的退出码，被信号终止时为 128+信号值
+func waitStatusCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// HandleOrphanExit 发布被 wolf-hook 收养并回收的孤儿进程的 process_exit 事件
+func HandleOrphanExit(pid int, ws syscall.WaitStatus) {
+	events.Publish(events.TypeProcessExit, CurrentRunID(), events.ProcessExitData{
+		PID:      pid,
+		ExitCode: waitStatusCode(ws),
+		Orphan:   true,
+	})
+}
+
+// AppProcessGroup 返回应用进程组 id，没有启动应用时返回 0
+func AppProcessGroup() int {
+	appLock.Lock()
+	defer appLock.Unlock()
+
+	if app == nil {
+		return 0
+	}
+	return app.pgid
+}
+
+// appHome 返回最近一次启动的应用的 HOME，没有启动应用时 ok 为 false
+func appHome() (string, bool) {
+	appLock.Lock()
+	defer appLock.Unlock()
+
+	if app == nil {
+		return "", false
+	}
+	return app.home, true
+}
+
+// WaitAppExitCode 等待应用入口进程的退出码被记录，最多等待 timeout，没有启动应用或者超时时 ok 为 false。
+// 入口进程由 launchApp 回收，进程组为空后退出码可能还没有记录
+func WaitAppExitCode(timeout time.Duration) (int, bool) {
+	appLock.Lock()
+	cur := app
+	appLock.Unlock()
+	if cur == nil {
+		return 0, false
+	}
+
+	select {
+	case <-cur.exitedCh:
+	case <-time.After(timeout):
+		return 0, false
+	}
+	appLock.Lock()
+	defer appLock.Unlock()
+
+	return cur.exitCode, true
+}
+
+// SignalApp 向应用所在进程组发送信号
+func SignalApp(sig syscall.Signal) error {
+	pgid := AppProcessGroup()
+	if pgid == 0 {
+		return errors.Errorf("no app launched")
+	}
+	return procutils.SignalGroup(pgid, sig)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/events"
+)
+
+const eventsHeartbeatInterval = 15 * time.Second
+
+type eventsController struct {
+	bus *events.Bus
+}
+
+func NewEventsController() http.Handler {
+	return &eventsController{bus: events.Default()}
+}
+
+// ServeHTTP 以 Server-Sent Events 推送生命周期事件。
+// 通过 Last-Event-ID 头或者 last_event_id 参数从指定事件之后重放，
+// types 参数按逗号分隔过滤事件类型。无法完整重放时先发送 events_lost 事件
+func (e *eventsController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastIDStr := r.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	if lastIDStr != "" {
+		var err error
+		lastID, err = strconv.ParseUint(lastIDStr, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid last event id %q", lastIDStr), http.StatusBadRequest)
+			return
+		}
+	}
+	types := make(map[events.Type]bool)
+	if typesStr := r.URL.Query().Get("types"); typesStr != "" {
+		for _, t := range strings.Split(typesStr, ",") {
+			types[events.Type(strings.TrimSpace(t))] = true
+		}
+	}
+
+	replay, sub := e.bus.Subscribe(lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev events.Event) error {
+		// events_lost 不受 types 过滤，订阅方需要据此重新同步
+		if len(types) > 0 && !types[ev.Type] && ev.Type != events.TypeEventsLost {
+			return nil
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, ev := range replay {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				log.Warningf("event subscriber %s is too slow, disconnect", r.RemoteAddr)
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
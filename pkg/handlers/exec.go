@@ -2,12 +2,20 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
+	"syscall"
+	"time"
 
 	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/metrics"
+	"github.com/zexi/wolf-hook/pkg/redact"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
 )
 
 type execController struct{}
@@ -16,42 +24,65 @@ func NewExecController() http.Handler {
 	return new(execController)
 }
 
-type ExecParams struct {
-	Cmd  string   `json:"cmd"`  // 要执行的命令
-	Args []string `json:"args"` // 命令参数
-	User string   `json:"user"` // 执行命令的用户
-}
-
-type ExecResponse struct {
-	Output string `json:"output"`          // 命令输出
-	Error  string `json:"error,omitempty"` // 错误信息，如果有的话
-}
-
 func (e *execController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Infof("Exec request received: %s", r.URL.Path)
 
+	if !config.Get().Policies.AllowExec {
+		log.Warningf("exec is disabled by policy")
+		http.Error(w, "exec is disabled by policy", http.StatusForbidden)
+		return
+	}
+
 	// 解析请求参数
-	params := new(ExecParams)
+	params := new(api.ExecParams)
 	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
 		log.Errorf("解析请求参数失败: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	var cred *userCredential
+	if params.User != "" {
+		var err error
+		cred, err = lookupUserCredential(params.User)
+		if err != nil {
+			log.Errorf("查找用户 %q 失败: %v", params.User, err)
+			writeJSON(w, http.StatusBadRequest, api.ExecResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	// 参数和输出中可能包含令牌等敏感值，记录日志和发布事件前先隐藏
+	redactor := redact.Default()
+	log.Infof("exec command: %s %v", params.Cmd, redactor.Args(params.Args))
+
 	// 执行命令
-	output, err := e.runCommand(params.Cmd, params.Args, params.User)
+	start := time.Now()
+	output, err := e.runCommand(params.Cmd, params.Args, cred)
+	data := events.ExecCompletedData{
+		Cmd:        params.Cmd,
+		Args:       redactor.Args(params.Args),
+		ExitCode:   exitCodeOf(err),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	events.Publish(events.TypeExecCompleted, "", data)
+	metrics.ExecJobs.Inc(metrics.ResultLabel(err))
+	metrics.ExecDuration.Observe(time.Since(start).Seconds())
 
 	// 准备响应
-	response := ExecResponse{
+	response := api.ExecResponse{
 		Output: string(output),
 	}
 
 	if err != nil {
-		log.Errorf("执行命令失败: %v, output: %s", err, output)
+		log.Errorf("执行命令失败: %v, output: %s", err, redactor.Text(output))
 		response.Error = err.Error()
 		w.WriteHeader(http.StatusInternalServerError)
 	} else {
-		log.Infof("命令执行成功: %s", output)
+		log.Infof("命令执行成功: %s", redactor.Text(output))
 		w.WriteHeader(http.StatusOK)
 	}
 
@@ -63,15 +94,17 @@ func (e *execController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (e *execController) runCommand(command string, args []string, user string) (string, error) {
+// runCommand 执行命令，cred 不为空时切换到该用户并设置 HOME 等环境变量
+func (e *execController) runCommand(command string, args []string, cred *userCredential) (string, error) {
 	// 设置命令的环境变量
 	env := os.Environ()
-	env = append(env, fmt.Sprintf("USER=%s", user))
-
-	// 执行命令
 	cmd := exec.Command(command, args...)
+	if cred != nil {
+		env = append(env, cred.env(env)...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred.sysCredential()}
+	}
 	cmd.Env = env
 
-	output, err := cmd.CombinedOutput()
+	output, err := procutils.CombinedOutput(cmd)
 	return string(output), err
 }
@@ -1,6 +1,9 @@
 package handlers
 
-import "net/http"
+import (
+	"encoding/json"
+	"net/http"
+)
 
 type getStatusController struct{}
 
@@ -8,8 +11,16 @@ func NewGetStatusController() http.Handler {
 	return new(getStatusController)
 }
 
+// ServeHTTP 默认返回 JSON 格式的状态，format=text 时返回旧版本的纯文本状态
 func (g getStatusController) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	status := GetStatus()
+	status.Resources = appResourceUsage()
+	if request.URL.Query().Get("format") == "text" {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(status.State.Legacy()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(GetState()))
-	return
+	json.NewEncoder(w).Encode(status)
 }
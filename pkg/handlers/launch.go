@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/resources"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+// launchSpec 是合并了请求参数和配置后实际启动应用的方式
+type launchSpec struct {
+	Command string
+	Args    []string
+	WorkDir string
+	// User 不为空时以该用户的身份和附加组运行
+	User *userCredential
+	UID  *int
+	GID  *int
+	// Umask 小于 0 时继承 wolf-hook 的 umask
+	Umask   int
+	Rlimits map[string]resources.Rlimit
+	// Cgroup 为空时不使用 cgroup
+	Cgroup *cgroupSpec
+}
+
+// cgroupSpec 是应用所在 cgroup 的位置和限制
+type cgroupSpec struct {
+	Root   string
+	Name   string
+	Limits resources.CgroupLimits
+}
+
+// paramsError 是请求参数不合法或者不被允许时的错误，Code 为返回的 HTTP 状态码
+type paramsError struct {
+	Code int
+	Err  error
+}
+
+func (e *paramsError) Error() string {
+	return e.Err.Error()
+}
+
+func badParams(format string, args ...interface{}) error {
+	return &paramsError{Code: http.StatusBadRequest, Err: errors.Errorf(format, args...)}
+}
+
+func forbiddenParams(format string, args ...interface{}) error {
+	return &paramsError{Code: http.StatusForbidden, Err: errors.Errorf(format, args...)}
+}
+
+// resolveLaunchSpec 用请求参数覆盖配置中的入口命令，
+// 非默认的命令需要在 entrypoint.allowed_commands 中，指定 user/uid/gid 需要开启 entrypoint.allow_user_override
+func resolveLaunchSpec(c *config.Config, params *api.StartParams) (*launchSpec, error) {
+	conf := c.Entrypoint
+	spec := &launchSpec{
+		Command: conf.Command,
+		Args:    conf.Args,
+		WorkDir: conf.WorkDir,
+		UID:     conf.UID,
+		GID:     conf.GID,
+		Umask:   -1,
+	}
+	if params.Command != "" && params.Command != conf.Command {
+		if !filepath.IsAbs(params.Command) {
+			return nil, badParams("command must be an absolute path: %q", params.Command)
+		}
+		if !isCommandAllowed(params.Command, conf.AllowedCommands) {
+			return nil, forbiddenParams("command %q is not allowed", params.Command)
+		}
+		spec.Command = params.Command
+		// 换了命令时不再使用默认命令的参数
+		spec.Args = nil
+	}
+	if params.Args != nil {
+		spec.Args = params.Args
+	}
+	if params.WorkDir != "" {
+		if !filepath.IsAbs(params.WorkDir) {
+			return nil, badParams("workdir must be an absolute path: %q", params.WorkDir)
+		}
+		spec.WorkDir = params.WorkDir
+	}
+	if params.User != "" || params.UID != nil || params.GID != nil {
+		if !conf.AllowUserOverride {
+			return nil, forbiddenParams("overriding user/uid/gid is not allowed")
+		}
+	}
+	if params.User != "" {
+		cred, err := lookupUserCredential(params.User)
+		if err != nil {
+			return nil, badParams("%v", err)
+		}
+		spec.setUser(cred)
+	} else if conf.User != "" {
+		cred, err := lookupUserCredential(conf.User)
+		if err != nil {
+			return nil, &paramsError{Code: http.StatusInternalServerError, Err: errors.Wrapf(err, "entrypoint.user")}
+		}
+		spec.setUser(cred)
+	}
+	if params.UID != nil || params.GID != nil {
+		if params.UID != nil {
+			if *params.UID < 0 {
+				return nil, badParams("invalid uid %d", *params.UID)
+			}
+			spec.UID = params.UID
+		}
+		if params.GID != nil {
+			if *params.GID < 0 {
+				return nil, badParams("invalid gid %d", *params.GID)
+			}
+			spec.GID = params.GID
+		}
+	}
+	umask := conf.Umask
+	if params.Umask != "" {
+		umask = params.Umask
+	}
+	if umask != "" {
+		mask, err := strconv.ParseUint(umask, 8, 32)
+		if err != nil || mask > 0777 {
+			return nil, badParams("invalid umask %q", umask)
+		}
+		spec.Umask = int(mask)
+	}
+	if err := resolveResources(c.Resources, params, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// resolveResources 合并配置和请求参数中的资源限制，请求参数需要开启 resources.allow_override
+func resolveResources(conf config.ResourcesConfig, params *api.StartParams, spec *launchSpec) error {
+	if (params.Rlimits != nil || params.Cgroup != nil) && !conf.AllowOverride {
+		return forbiddenParams("overriding resource limits is not allowed")
+	}
+	spec.Rlimits = make(map[string]resources.Rlimit, len(conf.Rlimits)+len(params.Rlimits))
+	for name, l := range conf.Rlimits {
+		spec.Rlimits[name] = l
+	}
+	for name, l := range params.Rlimits {
+		spec.Rlimits[name] = l
+	}
+	if err := resources.ValidateRlimits(spec.Rlimits); err != nil {
+		return badParams("%v", err)
+	}
+	if params.Cgroup != nil && !conf.Cgroup.Enabled {
+		return badParams("cgroup is not enabled by config")
+	}
+	if conf.Cgroup.Enabled {
+		limits := conf.Cgroup.CgroupLimits
+		if params.Cgroup != nil {
+			limits = limits.Merge(*params.Cgroup)
+		}
+		if err := limits.Validate(); err != nil {
+			return badParams("%v", err)
+		}
+		spec.Cgroup = &cgroupSpec{Root: conf.Cgroup.Root, Name: conf.Cgroup.Name, Limits: limits}
+	}
+	return nil
+}
+
+func (spec *launchSpec) setUser(cred *userCredential) {
+	spec.User = cred
+	spec.UID = &cred.UID
+	spec.GID = &cred.GID
+}
+
+func isCommandAllowed(command string, allowed []string) bool {
+	command = filepath.Clean(command)
+	for _, c := range allowed {
+		if filepath.Clean(c) == command {
+			return true
+		}
+	}
+	return false
+}
+
+// command 根据 launchSpec 创建命令
+func (spec *launchSpec) command() *exec.Cmd {
+	cmd := exec.Command(spec.Command, spec.Args...)
+	cmd.Dir = spec.WorkDir
+	// 应用放在独立的进程组中，收到信号时整组转发
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if spec.UID != nil || spec.GID != nil {
+		cred := &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		}
+		if spec.UID != nil {
+			cred.Uid = uint32(*spec.UID)
+		}
+		if spec.GID != nil {
+			cred.Gid = uint32(*spec.GID)
+		}
+		if spec.User != nil {
+			cred.Groups = spec.User.sysCredential().Groups
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+	return cmd
+}
+
+// environ 返回应用的环境变量，指定了用户时设置该用户的 HOME 等变量，envs 优先
+func (spec *launchSpec) environ(envs map[string]string) []string {
+	env := os.Environ()
+	if spec.User != nil {
+		env = append(env, spec.User.env(env)...)
+	}
+	for k, v := range envs {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env
+}
+
+// setupCgroup 创建应用的 cgroup，cgroup v2 不可用或者不可写时返回 nil，应用不受 cgroup 限制
+func (spec *launchSpec) setupCgroup() *resources.Cgroup {
+	if spec.Cgroup == nil {
+		return nil
+	}
+	cg, err := resources.SetupCgroup(spec.Cgroup.Root, spec.Cgroup.Name, spec.Cgroup.Limits)
+	if err != nil {
+		log.Warningf("setup cgroup %s in %s: %v, run app without cgroup limits", spec.Cgroup.Name, spec.Cgroup.Root, err)
+		return nil
+	}
+	return cg
+}
+
+// launchRlimits 按名称顺序返回辅助进程需要设置的资源限制
+func (spec *launchSpec) launchRlimits() ([]procutils.LaunchRlimit, error) {
+	names := make([]string, 0, len(spec.Rlimits))
+	for name := range spec.Rlimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]procutils.LaunchRlimit, 0, len(names))
+	for _, name := range names {
+		res, err := resources.RlimitResource(name)
+		if err != nil {
+			return nil, err
+		}
+		l := spec.Rlimits[name]
+		ret = append(ret, procutils.LaunchRlimit{
+			Name:     name,
+			Resource: res,
+			Limit:    syscall.Rlimit{Cur: uint64(l.Soft), Max: uint64(l.Hard)},
+		})
+	}
+	return ret, nil
+}
+
+// start 启动命令。指定了 umask、资源限制或 cgroup 时通过启动辅助进程启动：
+// 辅助进程 exec 前先被迁入 cgroup，再由它自己设置资源限制、切换用户和设置 umask，
+// 应用从第一条指令开始就受到限制，wolf-hook 自身的 umask、资源限制和 cgroup 不会被修改。
+// 返回应用所在的 cgroup，没有迁入 cgroup 时为 nil
+func (spec *launchSpec) start(cmd *exec.Cmd, cg *resources.Cgroup) (*resources.Cgroup, error) {
+	if spec.Umask < 0 && len(spec.Rlimits) == 0 && cg == nil {
+		return nil, procutils.StartCommand(cmd)
+	}
+	rlimits, err := spec.launchRlimits()
+	if err != nil {
+		return nil, err
+	}
+	gate, err := procutils.GateCommand(cmd, procutils.LaunchOptions{Umask: spec.Umask, Rlimits: rlimits})
+	if err != nil {
+		return nil, err
+	}
+	if err := procutils.StartCommand(cmd); err != nil {
+		gate.Close()
+		return nil, err
+	}
+	if cg != nil {
+		if err := cg.AddProcess(cmd.Process.Pid); err != nil {
+			log.Warningf("move app to cgroup %s: %v, run app without cgroup limits", cg.Path, err)
+			cg = nil
+		}
+	}
+	if err := gate.Release(); err != nil {
+		return nil, err
+	}
+	return cg, nil
+}
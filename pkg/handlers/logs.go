@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/applog"
+)
+
+const defaultLogsTail = 100
+
+type logsController struct{}
+
+func NewLogsController() http.Handler {
+	return new(logsController)
+}
+
+// ServeHTTP 返回应用的输出。
+// tail 指定返回最后多少行（默认 100，-1 表示全部），follow=true 时持续推送新输出，
+// stream 按 stdout/stderr 过滤，run_id 按运行过滤，format=json 时每行输出一个 JSON 对象
+func (l *logsController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tail := defaultLogsTail
+	if v := query.Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < -1 {
+			http.Error(w, fmt.Sprintf("invalid tail %q", v), http.StatusBadRequest)
+			return
+		}
+		tail = n
+	}
+	follow := false
+	if v := query.Get("follow"); v != "" {
+		var err error
+		follow, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid follow %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	filter := applog.Filter{
+		Stream: query.Get("stream"),
+		RunID:  query.Get("run_id"),
+	}
+	switch filter.Stream {
+	case "", applog.StreamStdout, applog.StreamStderr:
+	default:
+		http.Error(w, fmt.Sprintf("invalid stream %q", filter.Stream), http.StatusBadRequest)
+		return
+	}
+	asJSON := query.Get("format") == "json"
+
+	var flusher http.Flusher
+	if follow {
+		var ok bool
+		flusher, ok = w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	lines, sub := applog.Default().Tail(tail, filter, follow)
+	if sub != nil {
+		defer sub.Close()
+	}
+
+	if asJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(line applog.Line) error {
+		if asJSON {
+			return json.NewEncoder(w).Encode(line)
+		}
+		_, err := io.WriteString(w, line.Text+"\n")
+		return err
+	}
+	for _, line := range lines {
+		if err := write(line); err != nil {
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line, ok := <-sub.C:
+			if !ok {
+				log.Warningf("logs follower %s is too slow, disconnect", r.RemoteAddr)
+				return
+			}
+			if !filter.Match(line) {
+				continue
+			}
+			if err := write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
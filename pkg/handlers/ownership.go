@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/ownership"
+)
+
+type ownershipController struct{}
+
+func NewOwnershipController() http.Handler {
+	return new(ownershipController)
+}
+
+// selectOwnershipRules 返回 names 对应的规则，names 为空时返回所有规则
+func selectOwnershipRules(rules []ownership.Rule, names []string) ([]ownership.Rule, error) {
+	if len(names) == 0 {
+		return rules, nil
+	}
+	byName := make(map[string]ownership.Rule, len(rules))
+	for _, rule := range rules {
+		if rule.Name != "" {
+			byName[rule.Name] = rule
+		}
+	}
+	ret := make([]ownership.Rule, 0, len(names))
+	for _, name := range names {
+		rule, ok := byName[name]
+		if !ok {
+			return nil, errors.Errorf("no ownership rule named %q", name)
+		}
+		ret = append(ret, rule)
+	}
+	return ret, nil
+}
+
+// ownershipHome 返回解析相对路径使用的 HOME。
+// 优先使用最近一次启动的应用的 HOME，还没有启动应用时使用 entrypoint.user 的 HOME，
+// 都没有时应用以 wolf-hook 的身份运行，使用 wolf-hook 自己的 HOME
+func ownershipHome(conf *config.Config) (string, error) {
+	if home, ok := appHome(); ok {
+		return home, nil
+	}
+	if conf.Entrypoint.User == "" {
+		return os.Getenv("HOME"), nil
+	}
+	cred, err := lookupUserCredential(conf.Entrypoint.User)
+	if err != nil {
+		return "", errors.Wrapf(err, "entrypoint.user")
+	}
+	return cred.Home, nil
+}
+
+// ServeHTTP 立即执行配置中的属主和权限规则，dry_run 为 true 时只返回需要的修改，
+// 请求体为空时执行所有规则
+func (o *ownershipController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := new(api.OwnershipParams)
+	dec := json.NewDecoder(r.Body)
+	// 只能按名字选择配置中的规则，拒绝未知字段，避免拼错的参数被当作执行所有规则
+	dec.DisallowUnknownFields()
+	// 空的请求体表示执行所有规则
+	if err := dec.Decode(params); err != nil && err != io.EOF {
+		log.Errorf("解析请求参数失败: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conf := config.Get()
+	rules, err := selectOwnershipRules(conf.Ownership.EffectiveRules(), params.Names)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	home, err := ownershipHome(conf)
+	if err != nil {
+		log.Errorf("resolve app home: %v", err)
+		writeJSON(w, http.StatusInternalServerError, api.OwnershipResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := ownership.Apply(r.Context(), rules, ownership.Options{
+		Home:   home,
+		DryRun: params.DryRun,
+	})
+	resp := api.OwnershipResponse{Report: report}
+	code := http.StatusOK
+	if err != nil {
+		log.Errorf("apply ownership rules: %v", err)
+		resp.Error = err.Error()
+		code = http.StatusInternalServerError
+	}
+	log.Infof("ownership rules: %s", report.Summary())
+	writeJSON(w, code, resp)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/util/procfs"
+)
+
+type processesController struct{}
+
+func NewProcessesController() http.Handler {
+	return new(processesController)
+}
+
+// buildProcessTree 按 ppid 把进程组织成树，父进程不在列表中的进程作为根
+func buildProcessTree(nodes []*api.ProcessNode) []*api.ProcessNode {
+	byPid := make(map[int]*api.ProcessNode, len(nodes))
+	for _, n := range nodes {
+		byPid[n.Pid] = n
+	}
+	roots := make([]*api.ProcessNode, 0)
+	for _, n := range nodes {
+		if parent, ok := byPid[n.PPid]; ok && parent != n {
+			parent.Children = append(parent.Children, n)
+			continue
+		}
+		roots = append(roots, n)
+	}
+	return roots
+}
+
+// ServeHTTP 返回容器中的所有进程，tree=true 时按父子关系返回进程树
+func (p *processesController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	procs, err := procfs.ListProcesses()
+	if err != nil {
+		log.Errorf("list processes: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nodes := make([]*api.ProcessNode, 0, len(procs))
+	for _, proc := range procs {
+		nodes = append(nodes, &api.ProcessNode{Process: proc})
+	}
+	if r.URL.Query().Get("tree") == "true" {
+		nodes = buildProcessTree(nodes)
+	}
+	writeJSON(w, http.StatusOK, api.ProcessesResponse{
+		AppPgid:   AppProcessGroup(),
+		Processes: nodes,
+	})
+}
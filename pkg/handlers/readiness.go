@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/probe"
+)
+
+// runReadiness 在应用进入 RUNNING 后定期执行就绪探测，全部成功时进入 READY 状态，
+// 超时仍未就绪时进入 FAILED 状态
+func runReadiness(conf config.ReadinessConfig, runID string) {
+	probes := make([]probe.Probe, 0, len(conf.Probes))
+	for _, pc := range conf.Probes {
+		p, err := probe.New(pc)
+		if err != nil {
+			if err := setRunNotReady(runID, errors.Wrap(err, "create readiness probe")); err != nil {
+				log.Warningf("set run %s failed: %v", runID, err)
+			}
+			return
+		}
+		probes = append(probes, p)
+	}
+
+	timeout := conf.Timeout.Duration()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkReadiness(probes)
+		if err == nil {
+			if err := setRunReady(runID); err != nil {
+				log.Warningf("set run %s ready: %v", runID, err)
+				return
+			}
+			log.Infof("app is ready")
+			return
+		}
+		if CurrentRunID() != runID || GetState() != api.STATE_RUNNING {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Errorf("app is not ready in %s: %v", timeout, err)
+			if err := setRunNotReady(runID, errors.Wrapf(err, "not ready in %s", timeout)); err != nil {
+				log.Warningf("set run %s failed: %v", runID, err)
+			}
+			return
+		}
+		time.Sleep(conf.Interval.Duration())
+	}
+}
+
+// checkReadiness 依次执行探测，返回第一个失败的错误
+func checkReadiness(probes []probe.Probe) error {
+	for _, p := range probes {
+		if err := p.Check(context.Background()); err != nil {
+			return errors.Wrapf(err, "readiness probe %s", p)
+		}
+	}
+	return nil
+}
+
+// waitRunSettledInterval 是事件订阅被断开后检查状态的间隔
+const waitRunSettledInterval = 500 * time.Millisecond
+
+func isSettled(s api.STATE) bool {
+	switch s {
+	case api.STATE_READY, api.STATE_EXITED, api.STATE_FAILED:
+		return true
+	}
+	return false
+}
+
+// waitRunSettled 等待 runID 对应的运行就绪或者失败，返回最终状态。
+// sub 需要在运行开始前订阅，避免错过状态变化
+func waitRunSettled(ctx context.Context, sub *events.Subscription, runID string) (api.STATE, error) {
+	ch := sub.C
+	ticker := time.NewTicker(waitRunSettledInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case ev, ok := <-ch:
+			if !ok {
+				// 订阅被断开，之后只定期检查状态
+				ch = nil
+				continue
+			}
+			if ev.RunID != runID || ev.Type != events.TypeStateChanged {
+				continue
+			}
+			if data, ok := ev.Data.(events.StateChangedData); ok && isSettled(api.STATE(data.To)) {
+				return api.STATE(data.To), nil
+			}
+		case <-ticker.C:
+			st := GetStatus()
+			if st.RunID != runID {
+				return "", errors.Errorf("run %s is replaced by %s", runID, st.RunID)
+			}
+			if isSettled(st.State) {
+				return st.State, nil
+			}
+		}
+	}
+}
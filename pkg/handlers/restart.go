@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/metrics"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+// launchRequest 记录一次启动使用的参数，用于重启应用
+type launchRequest struct {
+	spec   *launchSpec
+	params *api.StartParams
+}
+
+var (
+	lastLaunch     *launchRequest
+	lastLaunchLock sync.Mutex
+)
+
+// maxLastExitCodes 是状态中保留的最近退出码的个数
+const maxLastExitCodes = 10
+
+// restartTracker 记录重启历史并调度延迟重启
+type restartTracker struct {
+	mu     sync.Mutex
+	status api.RestartStatus
+	// history 是窗口内各次重启的时间
+	history  []time.Time
+	timer    *time.Timer
+	disabled bool
+}
+
+var restarts = new(restartTracker)
+
+// publishLocked 把重启状态同步到 /hook/status，调用方需要持有 mu
+func (t *restartTracker) publishLocked() {
+	setRestartStatus(t.status.Copy())
+}
+
+// reset 在用户启动新的运行时清空重启历史
+func (t *restartTracker) reset(policy string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.history = nil
+	t.status = api.RestartStatus{Policy: policy}
+	t.publishLocked()
+}
+
+// recordExit 记录一次运行的退出码
+func (t *restartTracker) recordExit(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastExitCodes = append(t.status.LastExitCodes, code)
+	if n := len(t.status.LastExitCodes); n > maxLastExitCodes {
+		t.status.LastExitCodes = t.status.LastExitCodes[n-maxLastExitCodes:]
+	}
+	t.publishLocked()
+}
+
+// nextDelayLocked 返回下一次重启前的等待时间，窗口内重启次数达到上限时返回 false
+func (t *restartTracker) nextDelayLocked(conf config.RestartConfig, now time.Time) (time.Duration, bool) {
+	recent := t.history[:0]
+	for _, at := range t.history {
+		if conf.Window <= 0 || now.Sub(at) < conf.Window.Duration() {
+			recent = append(recent, at)
+		}
+	}
+	t.history = recent
+	if conf.MaxRestarts > 0 && len(t.history) >= conf.MaxRestarts {
+		return 0, false
+	}
+	delay := conf.InitialBackoff.Duration()
+	for i := 0; i < len(t.history) && delay < conf.MaxBackoff.Duration(); i++ {
+		delay *= 2
+	}
+	if delay > conf.MaxBackoff.Duration() {
+		delay = conf.MaxBackoff.Duration()
+	}
+	return delay, true
+}
+
+// acquire 记录一次立即执行的重启，达到上限时返回 false
+func (t *restartTracker) acquire(conf config.RestartConfig) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.disabled {
+		return false
+	}
+	if _, ok := t.nextDelayLocked(conf, now); !ok {
+		t.status.LimitReached = true
+		t.publishLocked()
+		return false
+	}
+	t.history = append(t.history, now)
+	t.status.Count++
+	metrics.AppRestarts.Inc()
+	t.publishLocked()
+	return true
+}
+
+// schedule 按退避时间调度 runID 之后的重启
+func (t *restartTracker) schedule(conf config.RestartConfig, runID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.disabled {
+		return
+	}
+	now := time.Now()
+	delay, ok := t.nextDelayLocked(conf, now)
+	if !ok {
+		log.Warningf("app restarted %d times in %s, give up restarting", len(t.history), conf.Window.Duration())
+		t.status.LimitReached = true
+		t.publishLocked()
+		return
+	}
+	at := now.Add(delay)
+	t.status.NextRestartAt = &at
+	t.publishLocked()
+	log.Infof("restart app in %s (policy %s)", delay, conf.Policy)
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(delay, func() {
+		if err := t.fire(runID); err != nil {
+			log.Errorf("restart app: %v", err)
+		}
+	})
+}
+
+func (t *restartTracker) fire(runID string) error {
+	// 被看门狗标记失败的运行或者入口进程退出后的进程组中可能还有进程，重启前先终止
+	if st := GetStatus(); st.RunID == runID && !st.State.IsActive() {
+		terminateLeftover(config.Get().Shutdown.GracePeriod.Duration())
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.timer = nil
+	t.status.NextRestartAt = nil
+	t.publishLocked()
+	if t.disabled {
+		return nil
+	}
+	// 期间用户已经启动了新的运行
+	if st := GetStatus(); st.RunID != runID || st.State.IsActive() {
+		return nil
+	}
+	lastLaunchLock.Lock()
+	req := lastLaunch
+	lastLaunchLock.Unlock()
+
+	t.history = append(t.history, time.Now())
+	t.status.Count++
+	metrics.AppRestarts.Inc()
+	t.publishLocked()
+	if _, _, err := BeginRun(newRunID(), ""); err != nil {
+		return errors.Wrap(err, "begin run")
+	}
+	startLaunch(config.Get(), req.spec, req.params)
+	return nil
+}
+
+// cancel 取消等待中的重启，之后仍然可以自动重启
+func (t *restartTracker) cancel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timer == nil {
+		return
+	}
+	t.timer.Stop()
+	t.timer = nil
+	t.status.NextRestartAt = nil
+	t.publishLocked()
+}
+
+// DisableRestart 取消等待中的重启并禁止之后的自动重启，在退出前调用
+func DisableRestart() {
+	restarts.mu.Lock()
+	defer restarts.mu.Unlock()
+
+	restarts.disabled = true
+	if restarts.timer != nil {
+		restarts.timer.Stop()
+		restarts.timer = nil
+	}
+}
+
+// startLaunch 在后台启动应用，调用方需要已经通过 BeginRun 开始了新的运行
+func startLaunch(conf *config.Config, spec *launchSpec, params *api.StartParams) {
+	lastLaunchLock.Lock()
+	lastLaunch = &launchRequest{spec: spec, params: params}
+	lastLaunchLock.Unlock()
+
+	// 上一次运行的看门狗不能再对新的运行执行动作
+	stopWatchdog()
+	runID := CurrentRunID()
+	go func() {
+		if err := new(startController).launchApp(conf, spec, params); err != nil {
+			log.Errorf("launch app failed: %v", err)
+			// 停止过程中入口进程被信号终止不算失败，由停止流程切换状态
+			if !isRunStopped(runID) {
+				if err := SetStateFailed(err); err != nil {
+					log.Errorf("set state failed: %v", err)
+				}
+			}
+		}
+		handleRunEnd(runID)
+	}()
+}
+
+// handleRunEnd 在入口进程退出后记录退出码，并按重启策略调度重启。
+// 入口进程退出但进程组中仍有进程时应用仍在运行，由看门狗负责检查
+func handleRunEnd(runID string) {
+	st := GetStatus()
+	if st.RunID != runID || (st.State != api.STATE_EXITED && st.State != api.STATE_FAILED) {
+		return
+	}
+	code := -1
+	if st.ExitCode != nil {
+		code = *st.ExitCode
+	}
+	restarts.recordExit(code)
+	// 被停止的运行不再自动重启
+	if _, ok := st.Timestamps[api.STATE_STOPPING]; ok {
+		return
+	}
+
+	conf := config.Get().Restart
+	switch conf.Policy {
+	case config.RestartPolicyAlways:
+	case config.RestartPolicyOnFailure:
+		if st.State != api.STATE_FAILED {
+			return
+		}
+	default:
+		return
+	}
+	restarts.schedule(conf, runID)
+}
+
+// terminateApp 向应用进程组发送 SIGTERM，超过 grace 后发送 SIGKILL
+func terminateApp(grace time.Duration) {
+	pgid := AppProcessGroup()
+	if pgid == 0 {
+		return
+	}
+	if err := procutils.SignalGroup(pgid, syscall.SIGTERM); err != nil {
+		log.Warningf("send SIGTERM to app group %d: %v", pgid, err)
+	}
+	if !procutils.WaitGroupExit(pgid, grace) {
+		log.Warningf("app group %d still alive after %s, send SIGKILL", pgid, grace)
+		if err := procutils.SignalGroup(pgid, syscall.SIGKILL); err != nil {
+			log.Warningf("send SIGKILL to app group %d: %v", pgid, err)
+		}
+	}
+}
+
+// terminateLeftover 终止应用进程组中剩余的进程，进程组中没有进程时不做任何事
+func terminateLeftover(grace time.Duration) {
+	pgid := AppProcessGroup()
+	if pgid == 0 {
+		return
+	}
+	if pids, err := procutils.GroupMembers(pgid); err != nil || len(pids) == 0 {
+		return
+	}
+	log.Infof("terminate processes left in app group %d before restart", pgid)
+	terminateApp(grace)
+}
+
+// restartApp 终止当前应用并用上一次启动的参数重新启动
+func restartApp(reason error) error {
+	lastLaunchLock.Lock()
+	req := lastLaunch
+	lastLaunchLock.Unlock()
+	if req == nil {
+		return errors.Errorf("no app launched")
+	}
+
+	conf := config.Get()
+	log.Infof("restart app: %v", reason)
+	terminateApp(conf.Shutdown.GracePeriod.Duration())
+	if GetState().IsActive() {
+		if err := SetStateFailed(reason); err != nil {
+			log.Warningf("set state failed: %v", err)
+		}
+	}
+	if !restarts.acquire(conf.Restart) {
+		return errors.Errorf("restart limit reached, app is not restarted")
+	}
+	if _, _, err := BeginRun(newRunID(), ""); err != nil {
+		return errors.Wrap(err, "begin run")
+	}
+	startLaunch(conf, req.spec, req.params)
+	return nil
+}
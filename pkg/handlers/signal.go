@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"syscall"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+type signalController struct{}
+
+func NewSignalController() http.Handler {
+	return new(signalController)
+}
+
+// maxCommLen 是内核保存的进程名的最大长度
+const maxCommLen = 15
+
+// signalMatcher 返回匹配目标进程的函数和目标的描述
+func signalMatcher(p *api.SignalParams) (func(*procutils.Process) bool, string, error) {
+	n := 0
+	for _, set := range []bool{p.PID != 0, p.Name != "", p.Cmdline != "", p.Target != ""} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return nil, "", errors.Errorf("exactly one of pid, name, cmdline and target is required")
+	}
+	switch {
+	case p.PID != 0:
+		if p.PID < 0 {
+			return nil, "", errors.Errorf("invalid pid %d", p.PID)
+		}
+		return func(proc *procutils.Process) bool {
+			return proc.Pid == p.PID
+		}, fmt.Sprintf("pid:%d", p.PID), nil
+	case p.Name != "":
+		name := p.Name
+		if len(name) > maxCommLen {
+			name = name[:maxCommLen]
+		}
+		return func(proc *procutils.Process) bool {
+			return proc.Comm == name
+		}, fmt.Sprintf("name:%s", p.Name), nil
+	case p.Cmdline != "":
+		re, err := regexp.Compile(p.Cmdline)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "invalid cmdline regexp %q", p.Cmdline)
+		}
+		return func(proc *procutils.Process) bool {
+			return proc.Cmdline != "" && re.MatchString(proc.Cmdline)
+		}, fmt.Sprintf("cmdline:%s", p.Cmdline), nil
+	}
+	if p.Target != api.SignalTargetApp {
+		return nil, "", errors.Errorf("unknown target %q", p.Target)
+	}
+	pgid := AppProcessGroup()
+	if pgid == 0 {
+		return nil, "", errors.Errorf("no app launched")
+	}
+	return func(proc *procutils.Process) bool {
+		return proc.Pgid == pgid
+	}, api.SignalTargetApp, nil
+}
+
+// ServeHTTP 向匹配的进程发送信号，wolf-hook 自身不会被匹配。
+// 没有匹配到进程时返回 404，有进程发送失败时返回 500
+func (s *signalController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := new(api.SignalParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
+		log.Errorf("解析请求参数失败: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sig, err := procutils.ParseSignal(params.Signal)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, api.SignalResponse{Signal: params.Signal, Processes: []api.SignalledProcess{}, Error: err.Error()})
+		return
+	}
+	resp := api.SignalResponse{
+		Signal:    procutils.SignalName(sig),
+		Processes: []api.SignalledProcess{},
+	}
+	match, target, err := signalMatcher(params)
+	if err != nil {
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+
+	procs, err := procutils.ListProcesses()
+	if err != nil {
+		log.Errorf("list processes: %v", err)
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, resp)
+		return
+	}
+	self := os.Getpid()
+	var pids []int
+	failed := false
+	for _, proc := range procs {
+		if proc.Pid == self || !match(proc) {
+			continue
+		}
+		// 容器的 1 号进程退出会导致容器退出，只在明确指定 pid 时发送
+		if proc.Pid == 1 && params.PID != 1 {
+			continue
+		}
+		sp := api.SignalledProcess{PID: proc.Pid, Comm: proc.Comm, Cmdline: proc.Cmdline}
+		if err := syscall.Kill(proc.Pid, sig); err != nil {
+			log.Warningf("send %s to process %d (%s): %v", resp.Signal, proc.Pid, proc.Comm, err)
+			sp.Error = err.Error()
+			failed = true
+		} else {
+			log.Infof("send %s to process %d (%s)", resp.Signal, proc.Pid, proc.Comm)
+			sp.Signalled = true
+			pids = append(pids, proc.Pid)
+		}
+		resp.Processes = append(resp.Processes, sp)
+	}
+	if len(pids) > 0 {
+		events.Publish(events.TypeSignalSent, CurrentRunID(), events.SignalSentData{Signal: resp.Signal, Target: target, PIDs: pids})
+	}
+
+	switch {
+	case len(resp.Processes) == 0:
+		resp.Error = fmt.Sprintf("no process matches %s", target)
+		writeJSON(w, http.StatusNotFound, resp)
+	case failed:
+		resp.Error = "failed to signal some processes"
+		writeJSON(w, http.StatusInternalServerError, resp)
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -1,196 +1,239 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
-	"os/exec"
-	"strings"
-	"time"
+	"syscall"
 
 	"yunion.io/x/log"
 	"yunion.io/x/pkg/errors"
-)
 
-const (
-	GOW_STARTUP_APP_SH = "/entrypoint.sh"
-	HOOK_ENV_FILE      = "/opt/bin/hook-env.sh"
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/applog"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/prestart"
+	"github.com/zexi/wolf-hook/pkg/redact"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
 )
 
-type startController struct {
-	noExitWhenAppLaunch bool
+type startController struct{}
+
+func NewStartController() http.Handler {
+	return new(startController)
 }
 
-func NewStartController(noExitWhenAppLaunch bool) http.Handler {
-	return &startController{
-		noExitWhenAppLaunch: noExitWhenAppLaunch,
+// redactedStartParams 返回隐藏了敏感环境变量和命令行参数的副本，用于记录日志
+func redactedStartParams(p api.StartParams) api.StartParams {
+	r := redact.Default()
+	p.Envs = r.Map(p.Envs)
+	if p.Args != nil {
+		p.Args = r.Args(p.Args)
 	}
+	return p
 }
 
-type StartParams struct {
-	Envs map[string]string `json:"envs"`
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
 }
 
 func (s startController) ServeHTTP(w http.ResponseWriter, request *http.Request) {
-	params := new(StartParams)
+	params := new(api.StartParams)
 	if err := json.NewDecoder(request.Body).Decode(params); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	go func() {
-		SetState(STATE_RUNNING)
-		if err := s.launchApp(params); err != nil {
-			log.Errorf("launch app failed: %v", err)
-			SetState(STATE_ERROR)
+	if key := request.Header.Get(api.HeaderIdempotencyKey); key != "" {
+		if params.IdempotencyKey != "" && params.IdempotencyKey != key {
+			http.Error(w, "idempotency key in header and body mismatch", http.StatusBadRequest)
+			return
 		}
-	}()
-	log.Printf("======get start params: %+v", params)
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("OK"))
-	return
-}
-
-// setupUdevControl 创建并设置 udev control 文件和目录
-func (s startController) setupUdevControl() error {
-	udevDir := "/run/udev"
-	udevDataDir := "/run/udev/data"
-	controlFile := "/run/udev/control"
-
-	// 确保 udev 目录存在
-	if err := os.MkdirAll(udevDir, 0777); err != nil {
-		log.Errorf("创建目录 %s 失败: %v", udevDir, err)
-		return errors.Wrap(err, "创建 udev 目录失败")
+		params.IdempotencyKey = key
 	}
+	log.Infof("======get start params: %+v", redactedStartParams(*params))
 
-	// 创建 data 目录
-	if err := os.MkdirAll(udevDataDir, 0777); err != nil {
-		log.Errorf("创建目录 %s 失败: %v", udevDataDir, err)
-		return errors.Wrap(err, "创建 udev data 目录失败")
+	// 每次启动使用当时生效的配置
+	conf := config.Get()
+	spec, err := resolveLaunchSpec(conf, params)
+	if err != nil {
+		log.Warningf("invalid start params: %v", err)
+		http.Error(w, err.Error(), err.(*paramsError).Code)
+		return
 	}
 
-	// 创建 control 文件
-	if _, err := os.Create(controlFile); err != nil {
-		log.Errorf("创建文件 %s 失败: %v", controlFile, err)
-		return errors.Wrap(err, "创建 control 文件失败")
+	st, started, err := BeginRun(newRunID(), params.IdempotencyKey)
+	resp := api.StartResponse{
+		RunID:          st.RunID,
+		State:          st.State,
+		IdempotencyKey: st.IdempotencyKey,
 	}
-
-	// 设置文件权限为 777
-	if err := os.Chmod(controlFile, 0777); err != nil {
-		log.Errorf("设置文件 %s 权限失败: %v", controlFile, err)
-		return errors.Wrap(err, "设置 control 文件权限失败")
+	if err != nil {
+		log.Errorf("begin run failed: %v", err)
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusConflict, resp)
+		return
 	}
-	return nil
-}
-
-func (s startController) setupSteamDir() error {
-	// 获取 HOME 环境变量
-	homeDir := os.Getenv("HOME")
-	if homeDir == "" {
-		return errors.Errorf("HOME 环境变量未设置")
+	if !started {
+		log.Infof("start request matches run %s, skip launching", st.RunID)
+		resp.Existing = true
+		writeJSON(w, http.StatusOK, resp)
+		return
 	}
-
-	// Steam 目录路径
-	steamDir := fmt.Sprintf("%s/.steam", homeDir)
-	debianInstallDir := fmt.Sprintf("%s/.steam/debian-installation", homeDir)
-
-	// 设置 .steam 目录权限
-	if _, err := os.Stat(steamDir); err == nil {
-		// 目录存在，设置权限
-		if err := os.Chown(steamDir, 1000, 1000); err != nil {
-			log.Errorf("设置目录 %s 权限失败: %v", steamDir, err)
-			return errors.Wrapf(err, "设置目录 %s 权限失败", steamDir)
-		}
-		log.Infof("已设置目录 %s 权限为 1000:1000", steamDir)
-	} else if os.IsNotExist(err) {
-		log.Infof("目录 %s 不存在，跳过权限设置", steamDir)
-	} else {
-		log.Errorf("检查目录 %s 时发生错误: %v", steamDir, err)
-		return errors.Wrapf(err, "检查目录 %s 失败", steamDir)
+	restarts.reset(conf.Restart.Policy)
+	if request.URL.Query().Get("wait") != "true" {
+		startLaunch(conf, spec, params)
+		writeJSON(w, http.StatusCreated, resp)
+		return
 	}
 
-	// 设置 .steam/debian-installation 目录权限
-	if _, err := os.Stat(debianInstallDir); err == nil {
-		// 目录存在，设置权限
-		if err := os.Chown(debianInstallDir, 1000, 1000); err != nil {
-			log.Errorf("设置目录 %s 权限失败: %v", debianInstallDir, err)
-			return errors.Wrapf(err, "设置目录 %s 权限失败", debianInstallDir)
+	// wait=true 时等待应用就绪或者失败后再返回
+	_, sub := events.Default().Subscribe(0)
+	defer sub.Close()
+	startLaunch(conf, spec, params)
+	state, err := waitRunSettled(request.Context(), sub, st.RunID)
+	if err != nil {
+		log.Warningf("wait run %s: %v", st.RunID, err)
+		resp.State = GetState()
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusInternalServerError, resp)
+		return
+	}
+	resp.State = state
+	if state != api.STATE_READY {
+		resp.Error = GetStatus().LastError
+		if resp.Error == "" {
+			resp.Error = fmt.Sprintf("app is %s before ready", state)
 		}
-		log.Infof("已设置目录 %s 权限为 1000:1000", debianInstallDir)
-	} else if os.IsNotExist(err) {
-		log.Infof("目录 %s 不存在，跳过权限设置", debianInstallDir)
-	} else {
-		log.Errorf("检查目录 %s 时发生错误: %v", debianInstallDir, err)
-		return errors.Wrapf(err, "检查目录 %s 失败", debianInstallDir)
+		writeJSON(w, http.StatusInternalServerError, resp)
+		return
 	}
-
-	return nil
+	writeJSON(w, http.StatusCreated, resp)
 }
 
-func (s startController) launchApp(params *StartParams) error {
-	// 设置 udev control 文件
-	if err := s.setupUdevControl(); err != nil {
-		return errors.Wrap(err, "设置 udev control 文件失败")
+func (s startController) launchApp(conf *config.Config, spec *launchSpec, params *api.StartParams) error {
+	cmd := spec.command()
+	cmd.Env = spec.environ(params.Envs)
+
+	sc := &prestart.Context{
+		RunID:  CurrentRunID(),
+		Config: conf,
+		Env:    cmd.Env,
+	}
+	if err := prestart.Run(context.Background(), sc, conf.Prestart.Steps, recordStepResult); err != nil {
+		return errors.Wrap(err, "启动前准备失败")
 	}
 
-	// 设置 Steam 目录权限
-	if err := s.setupSteamDir(); err != nil {
-		return errors.Wrapf(err, "设置 Steam 目录权限失败")
+	if err := SetState(api.STATE_STARTING); err != nil {
+		return err
 	}
 
-	cmd := exec.Command(GOW_STARTUP_APP_SH)
-	cmd.Env = os.Environ()
-	for k, v := range params.Envs {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	runID := CurrentRunID()
+	stdout, err := captureOutput(applog.StreamStdout, runID, os.Stdout)
+	if err != nil {
+		return err
 	}
-	var envContent string
-	for _, e := range cmd.Env {
-		pair := strings.SplitN(e, "=", 2)
-		envContent += fmt.Sprintf("export %s='%s'\n", pair[0], pair[1])
+	stderr, err := captureOutput(applog.StreamStderr, runID, os.Stderr)
+	if err != nil {
+		stdout.Close()
+		return err
 	}
-	if err := os.WriteFile(HOOK_ENV_FILE, []byte(envContent), 0644); err != nil {
-		log.Errorf("write env file failed: %v", err)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	log.Infof("launch app as subprocess: %v in %q with env: %v", redact.Default().Args(cmd.Args), cmd.Dir, redact.Default().Env(cmd.Environ()))
+	cg, err := spec.start(cmd, spec.setupCgroup())
+	// 子进程已经持有管道的写端，父进程的副本需要关闭，否则读端永远不会结束
+	stdout.Close()
+	stderr.Close()
+	if err != nil && cmd.Process != nil {
+		// 启动辅助进程已经运行，但设置资源限制或者 exec 应用失败
+		log.Errorf("launch app: %v, kill launch helper", err)
+		procutils.SignalGroup(cmd.Process.Pid, syscall.SIGKILL)
+		procutils.WaitCommand(cmd)
+	}
+	if err != nil {
+		log.Errorf("start app failed: %v", err)
+		return errors.Wrap(err, "start app failed")
+	}
+	setAppProcess(cmd.Process.Pid, sc.Getenv("HOME"))
+	if cg != nil {
+		setAppCgroup(cg)
+		log.Infof("app is running in cgroup %s", cg.Path)
+	}
+	if err := SetStateRunning(cmd.Process.Pid); err != nil {
+		log.Errorf("set state running: %v", err)
 	} else {
-		log.Infof("env content: \n%s", envContent)
+		go runReadiness(conf.Readiness, runID)
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	log.Infof("launch app as subprocess: %v with env: %v", cmd.Args, cmd.Environ())
-	if err := cmd.Run(); err != nil {
+	err = procutils.WaitCommand(cmd)
+	exitCode := exitCodeOf(err)
+	setAppExited(exitCode)
+	SetExitCode(exitCode)
+	exitData := events.ProcessExitData{PID: cmd.Process.Pid, ExitCode: exitCode}
+	if err != nil {
+		exitData.Error = err.Error()
+	}
+	events.Publish(events.TypeProcessExit, CurrentRunID(), exitData)
+	if err != nil {
 		log.Errorf("start app failed: %v", err)
 		return errors.Wrap(err, "start app failed")
 	}
+	// 入口脚本通常把应用放到后台后退出，进程组中没有进程时才认为应用已退出
+	if pids, err := procutils.GroupMembers(cmd.Process.Pid); err == nil && len(pids) == 0 {
+		if err := SetStateExited(); err != nil {
+			log.Errorf("set state exited: %v", err)
+		}
+	}
 
-	// 启动 goroutine 检查 sway 进程（如果未设置 no-exit-when-app-launch 标志）
-	if !s.noExitWhenAppLaunch {
-		go func() {
-			for {
-				// 检查是否有 sway 进程
-				if !isSwayRunning() {
-					log.Infof("未检测到 sway 进程，5 秒后退出程序")
-					time.Sleep(2 * time.Second)
-					log.Infof("退出程序")
-					os.Exit(134)
-				}
-				// 每隔一段时间检查一次
-				time.Sleep(3 * time.Second)
-			}
-		}()
+	// 入口进程退出后开始检查应用是否存活
+	if isRunStopped(runID) {
+		log.Infof("run %s is stopped, skip watchdog", runID)
+	} else if conf.Watchdog.Enabled {
+		if err := startWatchdog(conf.Watchdog, runID); err != nil {
+			log.Errorf("start watchdog: %v", err)
+		}
 	} else {
-		log.Infof("已设置 no-exit-when-app-launch 标志，跳过 sway 进程检测")
+		log.Infof("watchdog 已禁用，跳过进程检测")
 	}
 
 	return nil
 }
 
-// isSwayRunning 检查系统中是否有 sway 进程
-func isSwayRunning() bool {
-	cmd := exec.Command("pgrep", "sway")
-	if err := cmd.Run(); err != nil {
-		return false
+// recordStepResult 记录准备步骤的结果，并发布 step_result 事件
+func recordStepResult(result prestart.Result) {
+	addStepResult(result)
+	data := events.StepResultData{
+		Name:       result.Name,
+		Success:    result.Success,
+		Skipped:    result.Skipped,
+		DurationMs: result.DurationMs,
+		Error:      result.Error,
 	}
-	return true
+	events.Publish(events.TypeStepResult, CurrentRunID(), data)
+}
+
+// captureOutput 返回一个管道的写端，写入的内容按行保存到应用日志并镜像到 mirror。
+// 使用 *os.File 而不是 io.Writer 作为子进程的输出，入口脚本退出后 cmd.Wait 不会等待仍在运行的后台进程
+func captureOutput(stream, runID string, mirror io.Writer) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "create %s pipe", stream)
+	}
+	writer := applog.Default().NewWriter(stream, runID, mirror)
+	go func() {
+		defer r.Close()
+		defer writer.Close()
+		if _, err := io.Copy(writer, r); err != nil {
+			log.Errorf("copy app %s: %v", stream, err)
+		}
+	}()
+	return w, nil
 }
 
 /*func (s startController) launchExecApp(params *StartParams) error {
@@ -1,26 +1,243 @@
 package handlers
 
-import "sync"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
 
-type STATE string
+	"yunion.io/x/pkg/errors"
 
-const (
-	STATE_RUNNING STATE = "RUNNING"
-	STATE_STOPPED STATE = "STOPPED"
-	STATE_ERROR   STATE = "ERROR"
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/metrics"
+	"github.com/zexi/wolf-hook/pkg/prestart"
+	"github.com/zexi/wolf-hook/pkg/watchdog"
 )
 
-var state STATE
+// stateTransitions 定义允许的状态转换
+var stateTransitions = map[api.STATE][]api.STATE{
+	api.STATE_IDLE:      {api.STATE_PREPARING, api.STATE_STOPPING},
+	api.STATE_PREPARING: {api.STATE_STARTING, api.STATE_FAILED, api.STATE_STOPPING},
+	api.STATE_STARTING:  {api.STATE_RUNNING, api.STATE_FAILED, api.STATE_STOPPING},
+	api.STATE_RUNNING:   {api.STATE_READY, api.STATE_EXITED, api.STATE_FAILED, api.STATE_STOPPING},
+	api.STATE_READY:     {api.STATE_EXITED, api.STATE_FAILED, api.STATE_STOPPING},
+	api.STATE_STOPPING:  {api.STATE_EXITED, api.STATE_FAILED},
+	api.STATE_EXITED:    {api.STATE_PREPARING, api.STATE_STOPPING},
+	api.STATE_FAILED:    {api.STATE_PREPARING, api.STATE_STOPPING},
+}
+
+func canTransition(from, to api.STATE) bool {
+	for _, s := range stateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+var status = api.Status{
+	State:     api.STATE_IDLE,
+	UpdatedAt: time.Now(),
+}
 
 var stateLock sync.Mutex
 
-func SetState(s STATE) {
+var allStates = []string{
+	string(api.STATE_IDLE), string(api.STATE_PREPARING), string(api.STATE_STARTING), string(api.STATE_RUNNING),
+	string(api.STATE_READY), string(api.STATE_STOPPING), string(api.STATE_EXITED), string(api.STATE_FAILED),
+}
+
+func init() {
+	metrics.SetAppState(string(status.State), allStates)
+}
+
+func newRunID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// transitionLocked 切换状态并发布 state_changed 事件，调用方需要持有 stateLock
+func transitionLocked(to api.STATE, reason error) error {
+	from := status.State
+	if !canTransition(from, to) {
+		return errors.Errorf("invalid state transition %s -> %s", from, to)
+	}
+	now := time.Now()
+	status.State = to
+	status.UpdatedAt = now
+	if status.Timestamps == nil {
+		status.Timestamps = make(map[api.STATE]time.Time)
+	}
+	status.Timestamps[to] = now
+	data := events.StateChangedData{From: string(from), To: string(to)}
+	if reason != nil {
+		status.LastError = reason.Error()
+		data.Error = reason.Error()
+	}
+	events.Publish(events.TypeStateChanged, status.RunID, data)
+	metrics.SetAppState(string(to), allStates)
+	switch to {
+	case api.STATE_RUNNING:
+		metrics.SetAppStarted(now)
+	case api.STATE_EXITED, api.STATE_FAILED:
+		metrics.SetAppStarted(time.Time{})
+	}
+	return nil
+}
+
+// ErrRunActive 表示已经有另一次运行正在进行
+const ErrRunActive = errors.Error("another run is active")
+
+// BeginRun 开始一次新的运行，进入 PREPARING 状态并清空上一次运行的信息。
+// idempotencyKey 不为空且与正在进行的运行相同时不会开始新的运行，返回该运行的状态和 false，
+// 已经结束的运行不再匹配幂等键，使用相同的键重新启动会开始新的运行；
+// 有其他运行正在进行时返回 ErrRunActive
+func BeginRun(runID, idempotencyKey string) (api.Status, bool, error) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if idempotencyKey != "" && status.State.IsActive() && status.IdempotencyKey == idempotencyKey {
+		return status.Copy(), false, nil
+	}
+	if status.State.IsActive() {
+		return status.Copy(), false, errors.Wrapf(ErrRunActive, "run %s is %s", status.RunID, status.State)
+	}
+	if !canTransition(status.State, api.STATE_PREPARING) {
+		return status.Copy(), false, errors.Errorf("can't start new run in state %s", status.State)
+	}
+	status = api.Status{
+		State:          status.State,
+		RunID:          runID,
+		IdempotencyKey: idempotencyKey,
+		Restart:        status.Restart,
+		UpdatedAt:      status.UpdatedAt,
+	}
+	if err := transitionLocked(api.STATE_PREPARING, nil); err != nil {
+		return status.Copy(), false, err
+	}
+	return status.Copy(), true, nil
+}
+
+// SetState 切换到指定状态，非法的状态转换返回错误
+func SetState(s api.STATE) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return transitionLocked(s, nil)
+}
+
+// SetStateRunning 记录应用进程 pid 并进入 RUNNING 状态
+func SetStateRunning(pid int) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if err := transitionLocked(api.STATE_RUNNING, nil); err != nil {
+		return err
+	}
+	status.PID = pid
+	return nil
+}
+
+// setRunReady 在 runID 仍是当前运行且处于 RUNNING 状态时进入 READY 状态
+func setRunReady(runID string) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if status.RunID != runID || status.State != api.STATE_RUNNING {
+		return errors.Errorf("run %s is not running", runID)
+	}
+	return transitionLocked(api.STATE_READY, nil)
+}
+
+// setRunNotReady 在 runID 仍是当前运行且处于 RUNNING 状态时记录错误并进入 FAILED 状态
+func setRunNotReady(runID string, reason error) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if status.RunID != runID || status.State != api.STATE_RUNNING {
+		return errors.Errorf("run %s is not running", runID)
+	}
+	return transitionLocked(api.STATE_FAILED, reason)
+}
+
+// SetExitCode 记录应用入口进程的退出码
+func SetExitCode(exitCode int) {
 	stateLock.Lock()
 	defer stateLock.Unlock()
 
-	state = s
+	status.ExitCode = &exitCode
 }
 
-func GetState() STATE {
-	return state
+// SetStateExited 进入 EXITED 状态
+func SetStateExited() error {
+	return SetState(api.STATE_EXITED)
+}
+
+// SetStateFailed 记录错误信息并进入 FAILED 状态
+func SetStateFailed(err error) error {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return transitionLocked(api.STATE_FAILED, err)
+}
+
+// addStepResult 记录本次运行一个准备步骤的结果
+func addStepResult(result prestart.Result) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	status.Steps = append(status.Steps, result)
+}
+
+// setWatchdogStatus 更新 runID 对应运行的看门狗状态，runID 不是当前运行时忽略
+func setWatchdogStatus(runID string, st watchdog.Status) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	if status.RunID != runID {
+		return
+	}
+	status.Watchdog = &st
+}
+
+// setRestartStatus 更新自动重启的状态
+func setRestartStatus(rs api.RestartStatus) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	status.Restart = &rs
+}
+
+func GetState() api.STATE {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return status.State
+}
+
+// CurrentRunID 返回当前运行的 ID
+func CurrentRunID() string {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return status.RunID
+}
+
+// isRunStopped 返回 runID 是否是当前运行并且已经开始停止
+func isRunStopped(runID string) bool {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	_, ok := status.Timestamps[api.STATE_STOPPING]
+	return status.RunID == runID && ok
+}
+
+// GetStatus 返回当前状态的快照
+func GetStatus() api.Status {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return status.Copy()
 }
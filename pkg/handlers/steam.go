@@ -10,6 +10,8 @@ import (
 
 	"github.com/andygrunwald/vdf"
 	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/api"
 )
 
 type SteamOwnedGamesController struct{}
@@ -18,20 +20,6 @@ func NewSteamOwnedGamesController() *SteamOwnedGamesController {
 	return &SteamOwnedGamesController{}
 }
 
-type Game struct {
-	AppID      int    `json:"appid"`
-	Name       string `json:"name"`
-	PlayTime   int    `json:"playtime_forever"`
-	PlayTime2W int    `json:"playtime_2weeks,omitempty"`
-}
-
-type OwnedGamesResponse struct {
-	Response struct {
-		GameCount int    `json:"game_count"`
-		Games     []Game `json:"games"`
-	} `json:"response"`
-}
-
 func findSteamID64() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -64,7 +52,7 @@ func findSteamID64() (string, error) {
 	return steamIDs[0], nil
 }
 
-func parseLocalConfig(steamID64 string) ([]Game, error) {
+func parseLocalConfig(steamID64 string) ([]api.Game, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get home directory: %v", err)
@@ -103,7 +91,7 @@ func parseLocalConfig(steamID64 string) ([]Game, error) {
 		return nil, fmt.Errorf("no apps section")
 	}
 
-	var games []Game
+	var games []api.Game
 	for appidStr, v := range apps {
 		app, ok := v.(map[string]interface{})
 		if !ok {
@@ -119,7 +107,7 @@ func parseLocalConfig(steamID64 string) ([]Game, error) {
 		if pt2, ok := app["Playtime2wks"].(string); ok {
 			fmt.Sscanf(pt2, "%d", &playtime2wks)
 		}
-		games = append(games, Game{
+		games = append(games, api.Game{
 			AppID:      appid,
 			Name:       "", // localconfig.vdf 里没有名字
 			PlayTime:   playtime,
@@ -142,10 +130,10 @@ func (c *SteamOwnedGamesController) ServeHTTP(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	response := OwnedGamesResponse{
+	response := api.OwnedGamesResponse{
 		Response: struct {
-			GameCount int    `json:"game_count"`
-			Games     []Game `json:"games"`
+			GameCount int        `json:"game_count"`
+			Games     []api.Game `json:"games"`
 		}{
 			GameCount: len(games),
 			Games:     games,
@@ -1,14 +1,30 @@
 package handlers
 
 import (
-	"io/ioutil"
+	"encoding/json"
+	"io"
 	"net/http"
 	"os"
-	"strconv"
 	"syscall"
+	"time"
 
 	"yunion.io/x/log"
 	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+const (
+	// defaultStopExitCode 是停止后退出 wolf-hook 时默认使用的退出码，和旧版本保持一致
+	defaultStopExitCode = 123
+	// stopPollInterval 是等待进程退出时检查的间隔
+	stopPollInterval = 100 * time.Millisecond
+	// stopKillWait 是发送 SIGKILL 后等待进程消失的时间
+	stopKillWait = 2 * time.Second
+	// stopExitDelay 是返回响应后到退出 wolf-hook 的时间，保证响应发送到客户端
+	stopExitDelay = 200 * time.Millisecond
 )
 
 type stopController struct{}
@@ -17,61 +33,175 @@ func NewStopController() http.Handler {
 	return new(stopController)
 }
 
-// killOtherProcesses 终止除当前进程外的所有进程
-func (s *stopController) killOtherProcesses() error {
-	currentPid := os.Getpid()
+// stopTarget 是停止过程中跟踪的一个进程
+type stopTarget struct {
+	proc    *procutils.Process
+	signals []string
+	result  string
+}
 
-	// 读取 /proc 目录获取所有进程
-	dirs, err := ioutil.ReadDir("/proc")
-	if err != nil {
-		log.Errorf("读取 /proc 目录失败: %v", err)
-		return errors.Wrap(err, "读取 /proc 目录失败")
+func (t *stopTarget) signal(sig syscall.Signal) {
+	if err := syscall.Kill(t.proc.Pid, sig); err != nil {
+		if err != syscall.ESRCH {
+			log.Warningf("send %s to process %d: %v", sig, t.proc.Pid, err)
+		}
+		return
 	}
+	t.signals = append(t.signals, procutils.SignalName(sig))
+}
 
-	for _, dir := range dirs {
-		if !dir.IsDir() {
-			continue
+// waitTargets 等待 pending 中的进程退出，把退出的进程标记为 result，返回仍然存活的进程
+func waitTargets(pending []*stopTarget, timeout time.Duration, result string) []*stopTarget {
+	deadline := time.Now().Add(timeout)
+	for {
+		alive := pending[:0]
+		for _, t := range pending {
+			if procutils.IsAlive(t.proc.Pid) {
+				alive = append(alive, t)
+			} else {
+				t.result = result
+			}
 		}
-
-		// 尝试将目录名转换为 pid
-		pid, err := strconv.Atoi(dir.Name())
-		if err != nil {
-			continue // 不是进程目录
+		pending = alive
+		if len(pending) == 0 || !time.Now().Before(deadline) {
+			return pending
 		}
+		time.Sleep(stopPollInterval)
+	}
+}
 
-		// 跳过当前进程
-		if pid == currentPid {
-			continue
-		}
+// stopApp 向应用进程组及其子孙进程发送 SIGTERM，超过 grace 后对仍然存活的进程发送 SIGKILL
+func stopApp(grace time.Duration) ([]api.StoppedProcess, error) {
+	pgid := AppProcessGroup()
+	if pgid == 0 {
+		return []api.StoppedProcess{}, nil
+	}
+	procs, err := procutils.Tree(pgid)
+	if err != nil {
+		return nil, errors.Wrap(err, "list app processes")
+	}
+	targets := make([]*stopTarget, 0, len(procs))
+	for _, p := range procs {
+		t := &stopTarget{proc: p, signals: []string{}}
+		log.Infof("send SIGTERM to app process %d (%s)", p.Pid, p.Comm)
+		t.signal(syscall.SIGTERM)
+		targets = append(targets, t)
+	}
 
-		// 检查进程是否存在并发送 SIGTERM 信号
-		process, err := os.FindProcess(pid)
-		if err != nil {
-			continue
+	pending := waitTargets(append([]*stopTarget(nil), targets...), grace, api.StopResultTerminated)
+	// 等待期间新创建的进程直接发送 SIGKILL
+	if procs, err := procutils.Tree(pgid); err == nil {
+		known := make(map[int]bool, len(targets))
+		for _, t := range targets {
+			known[t.proc.Pid] = true
+		}
+		for _, p := range procs {
+			if !known[p.Pid] {
+				t := &stopTarget{proc: p, signals: []string{}}
+				targets = append(targets, t)
+				pending = append(pending, t)
+			}
+		}
+	}
+	if len(pending) > 0 {
+		for _, t := range pending {
+			log.Warningf("app process %d (%s) still alive after %s, send SIGKILL", t.proc.Pid, t.proc.Comm, grace)
+			t.signal(syscall.SIGKILL)
+		}
+		for _, t := range waitTargets(pending, stopKillWait, api.StopResultKilled) {
+			log.Errorf("app process %d (%s) survived SIGKILL", t.proc.Pid, t.proc.Comm)
+			t.result = api.StopResultSurvived
 		}
+	}
 
-		// 发送 SIGTERM 信号
-		if err := process.Signal(syscall.SIGTERM); err != nil {
-			log.Warningf("无法发送 SIGTERM 到进程 %d: %v", pid, err)
-			// 如果 SIGTERM 失败，尝试 SIGKILL
-			if err := process.Signal(syscall.SIGKILL); err != nil {
-				log.Errorf("无法发送 SIGKILL 到进程 %d: %v", pid, err)
+	ret := make([]api.StoppedProcess, 0, len(targets))
+	for _, t := range targets {
+		sp := api.StoppedProcess{
+			PID:     t.proc.Pid,
+			Comm:    t.proc.Comm,
+			Cmdline: t.proc.Cmdline,
+			Signals: t.signals,
+			Result:  t.result,
+		}
+		if t.proc.Pid == pgid {
+			if code, ok := WaitAppExitCode(10 * stopPollInterval); ok {
+				sp.ExitCode = &code
 			}
 		}
+		ret = append(ret, sp)
 	}
-	return nil
+	return ret, nil
 }
 
 func (s *stopController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Infof("Stop request: %s", r.URL.Path)
-	SetState(STATE_STOPPED)
-	w.WriteHeader(http.StatusAccepted)
-	go func() {
-		log.Infof("正在终止其他进程...")
-		if err := s.killOtherProcesses(); err != nil {
-			log.Errorf("终止其他进程时发生错误: %v", err)
+
+	params := new(api.StopParams)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil && err != io.EOF {
+		log.Errorf("解析请求参数失败: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	grace := config.Get().Shutdown.GracePeriod.Duration()
+	if params.GracePeriod != nil {
+		grace = params.GracePeriod.Duration()
+	}
+	if grace < 0 {
+		http.Error(w, "grace_period must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	// 停止过程中不能再自动重启
+	stopWatchdog()
+	restarts.cancel()
+
+	resp := &api.StopResponse{
+		RunID:         CurrentRunID(),
+		GracePeriodMs: grace.Milliseconds(),
+	}
+	if err := SetState(api.STATE_STOPPING); err != nil {
+		log.Warningf("set state stopping: %v", err)
+		resp.State = GetState()
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusConflict, resp)
+		return
+	}
+
+	procs, err := stopApp(grace)
+	if err != nil {
+		log.Errorf("stop app: %v", err)
+		resp.Error = err.Error()
+	}
+	resp.Processes = procs
+	if GetState() == api.STATE_STOPPING {
+		if err := SetStateExited(); err != nil {
+			log.Warningf("set state exited: %v", err)
+		}
+	}
+	resp.State = GetState()
+
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusInternalServerError
+	}
+	if params.Exit {
+		exitCode := defaultStopExitCode
+		if params.ExitCode != nil {
+			exitCode = *params.ExitCode
 		}
-	}()
-	log.Infof("======exit code 123")
-	os.Exit(123)
+		DisableRestart()
+		resp.Exiting = true
+		resp.ExitCode = &exitCode
+		writeJSON(w, code, resp)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		go func() {
+			time.Sleep(stopExitDelay)
+			log.Infof("======exit code %d", exitCode)
+			os.Exit(exitCode)
+		}()
+		return
+	}
+	writeJSON(w, code, resp)
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/zexi/wolf-hook/pkg/util/userutils"
+)
+
+// userCredential 是以目标用户运行命令时使用的身份和环境变量
+type userCredential struct {
+	Name   string
+	UID    int
+	GID    int
+	Groups []int
+	Home   string
+}
+
+// lookupUserCredential 从 /etc/passwd 和 /etc/group 查找用户的 uid、gid 和附加组，
+// 用户不存在时返回 userutils.ErrUserNotFound
+func lookupUserCredential(nameOrID string) (*userCredential, error) {
+	u, err := userutils.LookupUser(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := userutils.SupplementaryGroups(u)
+	if err != nil {
+		return nil, err
+	}
+	return &userCredential{
+		Name:   u.Name,
+		UID:    u.UID,
+		GID:    u.GID,
+		Groups: groups,
+		Home:   u.Home,
+	}, nil
+}
+
+// sysCredential 返回切换到该用户的 syscall.Credential
+func (c *userCredential) sysCredential() *syscall.Credential {
+	cred := &syscall.Credential{
+		Uid: uint32(c.UID),
+		Gid: uint32(c.GID),
+	}
+	for _, g := range c.Groups {
+		cred.Groups = append(cred.Groups, uint32(g))
+	}
+	return cred
+}
+
+// env 返回该用户的 HOME、USER、LOGNAME 和 XDG_RUNTIME_DIR。
+// base 中的 XDG_RUNTIME_DIR 是 wolf-hook 自己的 /run/user/<uid> 时才替换，
+// 容器通常把 Wayland 的 socket 目录共享为 XDG_RUNTIME_DIR，需要保留
+func (c *userCredential) env(base []string) []string {
+	env := []string{
+		"HOME=" + c.Home,
+		"USER=" + c.Name,
+		"LOGNAME=" + c.Name,
+	}
+	runtimeDir, ok := lookupEnv(base, "XDG_RUNTIME_DIR")
+	if !ok || runtimeDir == fmt.Sprintf("/run/user/%d", os.Getuid()) {
+		env = append(env, fmt.Sprintf("XDG_RUNTIME_DIR=/run/user/%d", c.UID))
+	}
+	return env
+}
+
+func lookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return env[i][len(key)+1:], true
+		}
+	}
+	return "", false
+}
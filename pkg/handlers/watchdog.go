@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/probe"
+	"github.com/zexi/wolf-hook/pkg/redact"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+	"github.com/zexi/wolf-hook/pkg/watchdog"
+)
+
+const watchdogHookTimeout = 30 * time.Second
+
+var (
+	watchdogCancel context.CancelFunc
+	watchdogLock   sync.Mutex
+)
+
+// stopWatchdog 停止当前运行的看门狗
+func stopWatchdog() {
+	watchdogLock.Lock()
+	defer watchdogLock.Unlock()
+
+	if watchdogCancel != nil {
+		watchdogCancel()
+		watchdogCancel = nil
+	}
+}
+
+// startWatchdog 为 runID 对应的运行启动看门狗，之前的看门狗会被停止
+func startWatchdog(conf config.WatchdogConfig, runID string) error {
+	p, err := probe.New(conf.ProbeConfig())
+	if err != nil {
+		return errors.Wrap(err, "create watchdog probe")
+	}
+	stopWatchdog()
+
+	watchdogLock.Lock()
+	defer watchdogLock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchdogCancel = cancel
+	wd := watchdog.New(watchdog.Options{
+		Probe:            p,
+		InitialDelay:     conf.InitialDelay.Duration(),
+		Interval:         conf.Interval.Duration(),
+		FailureThreshold: conf.FailureThreshold,
+		Action:           conf.Action,
+		OnFailure: func(err error) bool {
+			return runWatchdogAction(conf, runID, p, err)
+		},
+		OnUpdate: func(st watchdog.Status) {
+			setWatchdogStatus(runID, st)
+		},
+	})
+	go wd.Run(ctx)
+	return nil
+}
+
+// runWatchdogAction 执行看门狗的动作，返回是否继续检查
+func runWatchdogAction(conf config.WatchdogConfig, runID string, p probe.Probe, reason error) bool {
+	events.Publish(events.TypeWatchdogTriggered, runID, events.WatchdogTriggeredData{
+		Probe:  p.String(),
+		Action: conf.Action,
+		Error:  reason.Error(),
+	})
+	switch conf.Action {
+	case config.WatchdogActionExit:
+		log.Infof("watchdog 探测 %s 失败，%s 后以 %d 退出程序", p, conf.ExitDelay.Duration(), conf.ExitCode)
+		time.Sleep(conf.ExitDelay.Duration())
+		log.Infof("退出程序")
+		os.Exit(conf.ExitCode)
+	case config.WatchdogActionMarkFailed:
+		if err := SetStateFailed(errors.Wrapf(reason, "watchdog probe %s", p)); err != nil {
+			log.Errorf("set state failed: %v", err)
+			break
+		}
+		// 看门狗在入口进程退出后才运行，运行结束的处理已经跳过，标记失败后按重启策略处理
+		handleRunEnd(runID)
+	case config.WatchdogActionRestart:
+		go func() {
+			if err := restartApp(errors.Wrapf(reason, "watchdog probe %s", p)); err != nil {
+				log.Errorf("restart app: %v", err)
+			}
+		}()
+	case config.WatchdogActionHook:
+		runWatchdogHook(conf.HookCommand, runID, p, reason)
+		return true
+	}
+	return false
+}
+
+func runWatchdogHook(command []string, runID string, p probe.Probe, reason error) {
+	ctx, cancel := context.WithTimeout(context.Background(), watchdogHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("WOLF_HOOK_RUN_ID=%s", runID),
+		fmt.Sprintf("WOLF_HOOK_WATCHDOG_PROBE=%s", p),
+		fmt.Sprintf("WOLF_HOOK_WATCHDOG_ERROR=%s", reason),
+	)
+	output, err := procutils.CombinedOutput(cmd)
+	if err != nil {
+		log.Errorf("run watchdog hook %v: %v, output: %s", redact.Default().Args(command), err, redact.Default().Text(string(output)))
+		return
+	}
+	log.Infof("watchdog hook %v done, output: %s", redact.Default().Args(command), redact.Default().Text(string(output)))
+}
@@ -5,8 +5,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/events"
 )
 
 type writeHwdbController struct{}
@@ -15,22 +20,23 @@ func NewWriteHwdbController() http.Handler {
 	return new(writeHwdbController)
 }
 
-type WriteHwdbParams struct {
-	Path    string `json:"path"`    // 文件路径
-	Content string `json:"content"` // 文件内容
-}
-
 func (w *writeHwdbController) ServeHTTP(resp http.ResponseWriter, r *http.Request) {
 	log.Infof("Write hwdb request received: %s", r.URL.Path)
 
 	// 解析请求参数
-	params := new(WriteHwdbParams)
+	params := new(api.WriteHwdbParams)
 	if err := json.NewDecoder(r.Body).Decode(params); err != nil {
 		log.Errorf("解析请求参数失败: %v", err)
 		http.Error(resp, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if !isPathAllowed(params.Path, config.Get().Policies.WriteHwdbDirs) {
+		log.Warningf("写入路径 %s 不在允许的目录中", params.Path)
+		http.Error(resp, "path is not allowed by policy", http.StatusForbidden)
+		return
+	}
+
 	// 确保目标目录存在
 	dir := filepath.Dir(params.Path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -47,6 +53,22 @@ func (w *writeHwdbController) ServeHTTP(resp http.ResponseWriter, r *http.Reques
 	}
 
 	log.Infof("成功写入文件 %s", params.Path)
+	events.Publish(events.TypeFileWritten, "", events.FileWrittenData{Path: params.Path, Size: len(params.Content)})
 	resp.WriteHeader(http.StatusOK)
 	resp.Write([]byte("OK"))
 }
+
+// isPathAllowed 检查路径是否位于允许的目录中，dirs 为空时不做限制
+func isPathAllowed(path string, dirs []string) bool {
+	if len(dirs) == 0 {
+		return true
+	}
+	path = filepath.Clean(path)
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
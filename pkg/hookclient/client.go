@@ -0,0 +1,497 @@
+package hookclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/applog"
+	"github.com/zexi/wolf-hook/pkg/auth"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/listener"
+)
+
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 200 * time.Millisecond
+	DefaultTimeout      = 30 * time.Second
+	// DefaultWaitTimeout 是 StartAndWait 在 ctx 没有设置超时时等待的时间，
+	// 在服务端默认的就绪超时之外再留出启动前准备步骤和网络的时间
+	DefaultWaitTimeout = api.DefaultReadinessTimeout + DefaultTimeout
+)
+
+// APIError 是服务端返回的非 2xx 响应
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wolf-hook: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client 是 hook API 的客户端
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	token        string
+	hmacKeyID    string
+	hmacSecret   string
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient 指定底层的 http.Client，用于 unix socket 以外的自定义传输
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithToken 使用 bearer token 鉴权
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHMAC 使用 HMAC 签名鉴权
+func WithHMAC(keyID, secret string) Option {
+	return func(c *Client) {
+		c.hmacKeyID = keyID
+		c.hmacSecret = secret
+	}
+}
+
+// WithRetry 设置幂等请求失败时的重试次数和初始退避时间，退避时间每次翻倍
+func WithRetry(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// New 创建客户端，endpoint 可以是 http(s):// URL，
+// 也可以是和 --listen 参数一样的 tcp://host:port 或者 unix:///path/to/sock
+func New(endpoint string, opts ...Option) (*Client, error) {
+	c := &Client{
+		maxRetries:   DefaultMaxRetries,
+		retryBackoff: DefaultRetryBackoff,
+	}
+	var transport *http.Transport
+	if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		if _, err := url.Parse(endpoint); err != nil {
+			return nil, errors.Wrapf(err, "invalid endpoint %q", endpoint)
+		}
+		c.baseURL = strings.TrimRight(endpoint, "/")
+	} else {
+		addr, err := listener.ParseAddress(endpoint)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid endpoint %q", endpoint)
+		}
+		if addr.Scheme == listener.SchemeUnix {
+			c.baseURL = "http://unix"
+			transport = &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", addr.Addr)
+				},
+			}
+		} else {
+			c.baseURL = "http://" + addr.Addr
+		}
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{}
+		if transport != nil {
+			c.httpClient.Transport = transport
+		}
+	}
+	return c, nil
+}
+
+// Start 在后台启动应用，params.IdempotencyKey 不为空时重试是安全的；
+// 有其他运行正在进行时同时返回当前运行的信息和 409 的 *APIError
+func (c *Client) Start(ctx context.Context, params *api.StartParams) (*api.StartResponse, error) {
+	return c.start(ctx, params, nil)
+}
+
+// StartAndWait 启动应用并等待其就绪。应用在就绪前失败时返回的响应中包含失败状态和 *APIError。
+// 等待时间由服务端的就绪超时决定，ctx 没有设置超时时使用 DefaultWaitTimeout，
+// 服务端配置了更长的 readiness.timeout 时需要在 ctx 中设置相应的超时
+func (c *Client) StartAndWait(ctx context.Context, params *api.StartParams) (*api.StartResponse, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultWaitTimeout)
+		defer cancel()
+	}
+	return c.start(ctx, params, url.Values{"wait": []string{"true"}})
+}
+
+func (c *Client) start(ctx context.Context, params *api.StartParams, query url.Values) (*api.StartResponse, error) {
+	resp := new(api.StartResponse)
+	err := c.do(ctx, http.MethodPost, "/hook/start", query, params, resp)
+	if apiErr, ok := err.(*APIError); ok && (apiErr.StatusCode == http.StatusConflict || apiErr.StatusCode == http.StatusInternalServerError) {
+		if json.Unmarshal([]byte(apiErr.Message), resp) == nil && resp.RunID != "" {
+			if resp.Error != "" {
+				apiErr.Message = resp.Error
+			}
+			return resp, apiErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Stop 停止应用进程树并返回每个进程的处理结果，params 为空时使用默认参数。
+// 指定退出 wolf-hook 时连接可能在返回响应前被断开，此时返回 nil 且不视为错误
+func (c *Client) Stop(ctx context.Context, params *api.StopParams) (*api.StopResponse, error) {
+	if params == nil {
+		params = new(api.StopParams)
+	}
+	resp := new(api.StopResponse)
+	err := c.do(ctx, http.MethodPost, "/hook/stop", nil, params, resp)
+	if apiErr, ok := err.(*APIError); ok && (apiErr.StatusCode == http.StatusConflict || apiErr.StatusCode == http.StatusInternalServerError) {
+		if json.Unmarshal([]byte(apiErr.Message), resp) == nil && resp.Error != "" {
+			apiErr.Message = resp.Error
+			return resp, apiErr
+		}
+	}
+	if err == nil {
+		return resp, nil
+	}
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+	if params.Exit && (cause == io.EOF || cause == io.ErrUnexpectedEOF) {
+		return nil, nil
+	}
+	return nil, err
+}
+
+// Signal 向匹配的进程发送信号，有进程没有匹配到或者发送失败时同时返回结果和 *APIError
+func (c *Client) Signal(ctx context.Context, params *api.SignalParams) (*api.SignalResponse, error) {
+	resp := new(api.SignalResponse)
+	err := c.do(ctx, http.MethodPost, "/hook/signal", nil, params, resp)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusForbidden {
+		if json.Unmarshal([]byte(apiErr.Message), resp) == nil && resp.Error != "" {
+			apiErr.Message = resp.Error
+			return resp, apiErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Status 返回应用的生命周期状态
+func (c *Client) Status(ctx context.Context) (*api.Status, error) {
+	status := new(api.Status)
+	if err := c.do(ctx, http.MethodGet, "/hook/status", nil, nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Processes 返回容器中的进程，tree 为 true 时按父子关系返回进程树
+func (c *Client) Processes(ctx context.Context, tree bool) (*api.ProcessesResponse, error) {
+	var query url.Values
+	if tree {
+		query = url.Values{"tree": []string{"true"}}
+	}
+	resp := new(api.ProcessesResponse)
+	if err := c.do(ctx, http.MethodGet, "/hook/processes", query, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Exec 执行命令，命令执行失败时同时返回输出和 *APIError
+func (c *Client) Exec(ctx context.Context, params *api.ExecParams) (*api.ExecResponse, error) {
+	resp := new(api.ExecResponse)
+	err := c.do(ctx, http.MethodPost, "/hook/exec", nil, params, resp)
+	if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusInternalServerError {
+		// 命令执行失败时响应体仍然是 ExecResponse
+		json.Unmarshal([]byte(apiErr.Message), resp)
+		if resp.Error != "" {
+			apiErr.Message = resp.Error
+		}
+		return resp, apiErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// WriteHwdb 写入 hwdb 文件
+func (c *Client) WriteHwdb(ctx context.Context, params *api.WriteHwdbParams) error {
+	return c.do(ctx, http.MethodPost, "/hook/write-hwdb", nil, params, nil)
+}
+
+// OwnedGames 返回本地 Steam 配置中的游戏
+func (c *Client) OwnedGames(ctx context.Context) (*api.OwnedGamesResponse, error) {
+	resp := new(api.OwnedGamesResponse)
+	if err := c.do(ctx, http.MethodGet, "/steam/owned-games", nil, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Events 订阅生命周期事件，从 lastID 之后开始重放，types 为空时接收所有类型。
+// 阻塞直到 ctx 结束、连接断开或者 fn 返回错误；调用方可以用最后收到的事件 ID 重新订阅，
+// 收到 events_lost 事件时需要通过 Status 重新同步状态
+func (c *Client) Events(ctx context.Context, lastID uint64, types []events.Type, fn func(events.Event) error) error {
+	query := url.Values{}
+	if lastID > 0 {
+		query.Set("last_event_id", strconv.FormatUint(lastID, 10))
+	}
+	if len(types) > 0 {
+		ts := make([]string, len(types))
+		for i, t := range types {
+			ts[i] = string(t)
+		}
+		query.Set("types", strings.Join(ts, ","))
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/hook/events", query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "subscribe events")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// 空行表示一条消息结束
+			if data.Len() == 0 {
+				continue
+			}
+			ev := events.Event{}
+			if err := json.Unmarshal(data.Bytes(), &ev); err != nil {
+				return errors.Wrapf(err, "decode event %q", data.String())
+			}
+			data.Reset()
+			if err := fn(ev); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return errors.Wrap(err, "read events")
+	}
+	return ctx.Err()
+}
+
+// LogsOptions 是读取应用输出的参数
+type LogsOptions struct {
+	// Tail 为 0 时使用服务端默认值，-1 表示全部
+	Tail   int
+	Follow bool
+	Stream string
+	RunID  string
+}
+
+// Logs 读取应用输出，Follow 为 true 时阻塞直到 ctx 结束、连接断开或者 fn 返回错误
+func (c *Client) Logs(ctx context.Context, opts LogsOptions, fn func(applog.Line) error) error {
+	query := url.Values{}
+	query.Set("format", "json")
+	if opts.Tail != 0 {
+		query.Set("tail", strconv.Itoa(opts.Tail))
+	}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+	if opts.Stream != "" {
+		query.Set("stream", opts.Stream)
+	}
+	if opts.RunID != "" {
+		query.Set("run_id", opts.RunID)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/hook/logs", query, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "get logs")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		line := applog.Line{}
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrap(err, "decode log line")
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Request, error) {
+	uri := path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, c.baseURL+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "new request %s %s", method, uri)
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.hmacSecret != "":
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := newNonce()
+		req.Header.Set(auth.HeaderKeyID, c.hmacKeyID)
+		req.Header.Set(auth.HeaderTimestamp, ts)
+		req.Header.Set(auth.HeaderNonce, nonce)
+		req.Header.Set(auth.HeaderSignature, auth.Sign(c.hmacSecret, method, req.URL.RequestURI(), ts, nonce, body))
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do 发送请求并把 JSON 响应解析到 out 中，GET 和携带幂等键的启动请求在网络错误或者 429/5xx 时重试
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return errors.Wrap(err, "encode request")
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	idempotent := method == http.MethodGet || isIdempotentStart(path, in)
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		// 每次重试都需要重新签名，nonce 不能重复使用
+		req, err := c.newRequest(ctx, method, path, query, body)
+		if err != nil {
+			return err
+		}
+		err = c.send(req, out)
+		if err == nil || !idempotent || attempt >= c.maxRetries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s", req.Method, req.URL.Path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrapf(err, "decode response of %s %s", req.Method, req.URL.Path)
+	}
+	return nil
+}
+
+// isIdempotentStart 返回请求是否是携带幂等键的启动请求
+func isIdempotentStart(path string, in interface{}) bool {
+	params, ok := in.(*api.StartParams)
+	return ok && path == "/hook/start" && params.IdempotencyKey != ""
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(data)),
+	}
+	// 鉴权失败时响应体是 auth.ErrorResponse
+	errResp := auth.ErrorResponse{}
+	if json.Unmarshal(data, &errResp) == nil && errResp.Details != "" {
+		apiErr.Message = errResp.Details
+	}
+	return apiErr
+}
+
+func retryable(err error) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// 其余错误都是网络错误
+	return true
+}
+
+func newNonce() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
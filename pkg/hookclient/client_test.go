@@ -0,0 +1,158 @@
+package hookclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/auth"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/hookclient"
+	"github.com/zexi/wolf-hook/pkg/server"
+)
+
+const (
+	testToken     = "test-token"
+	testReadToken = "test-read-token"
+)
+
+// newTestServer 启动使用真实路由的服务端，应用是一个 sleep 进程，不执行启动前准备步骤和看门狗
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	conf := config.Default()
+	conf.Entrypoint.Command = "/bin/sleep"
+	conf.Entrypoint.Args = []string{"60"}
+	conf.Prestart.Steps = nil
+	conf.Watchdog.Enabled = false
+	conf.Shutdown.GracePeriod = config.Duration(2 * time.Second)
+	config.Set(conf)
+
+	a := auth.NewAuthenticator(&auth.Config{
+		Tokens: []auth.Token{
+			{Name: "test", Token: testToken, Scopes: []auth.Scope{auth.ScopeAll}},
+			{Name: "read", Token: testReadToken, Scopes: []auth.Scope{auth.ScopeRead}},
+		},
+	})
+	ts := httptest.NewServer(server.NewRouter(a))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestClient(t *testing.T, ts *httptest.Server, token string) *hookclient.Client {
+	t.Helper()
+
+	c, err := hookclient.New(ts.URL, hookclient.WithToken(token), hookclient.WithRetry(0, 0))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	return c
+}
+
+// stopApp 停止应用，保证各个测试之间不会互相影响
+func stopApp(t *testing.T, c *hookclient.Client) *api.StopResponse {
+	t.Helper()
+
+	grace := api.Duration(time.Second)
+	resp, err := c.Stop(context.Background(), &api.StopParams{GracePeriod: &grace})
+	if err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	return resp
+}
+
+func TestStartAndWaitStatusStop(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, ts, testToken)
+	ctx := context.Background()
+
+	started, err := c.StartAndWait(ctx, &api.StartParams{})
+	if err != nil {
+		t.Fatalf("start and wait: %v", err)
+	}
+	if started.State != api.STATE_READY || started.RunID == "" {
+		t.Fatalf("start response = %+v, want READY with run id", started)
+	}
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.State != api.STATE_READY || status.RunID != started.RunID || status.PID <= 0 {
+		t.Fatalf("status = %+v, want READY run %s with pid", status, started.RunID)
+	}
+
+	stopped := stopApp(t, c)
+	if stopped.State != api.STATE_EXITED || stopped.RunID != started.RunID {
+		t.Fatalf("stop response = %+v, want EXITED run %s", stopped, started.RunID)
+	}
+	var entry *api.StoppedProcess
+	for i := range stopped.Processes {
+		if stopped.Processes[i].PID == status.PID {
+			entry = &stopped.Processes[i]
+		}
+	}
+	if entry == nil || entry.Result != api.StopResultTerminated {
+		t.Fatalf("stop processes = %+v, want pid %d terminated", stopped.Processes, status.PID)
+	}
+
+	status, err = c.Status(ctx)
+	if err != nil {
+		t.Fatalf("status after stop: %v", err)
+	}
+	if status.State != api.STATE_EXITED {
+		t.Fatalf("status after stop = %s, want EXITED", status.State)
+	}
+}
+
+func TestStartIdempotencyKey(t *testing.T) {
+	ts := newTestServer(t)
+	c := newTestClient(t, ts, testToken)
+	ctx := context.Background()
+
+	params := &api.StartParams{IdempotencyKey: "launch-1"}
+	first, err := c.StartAndWait(ctx, params)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	again, err := c.Start(ctx, params)
+	if err != nil {
+		t.Fatalf("start again: %v", err)
+	}
+	if !again.Existing || again.RunID != first.RunID {
+		t.Fatalf("repeated start = %+v, want existing run %s", again, first.RunID)
+	}
+	stopApp(t, c)
+
+	// 运行结束后相同的键会启动新的运行
+	relaunched, err := c.StartAndWait(ctx, params)
+	if err != nil {
+		t.Fatalf("relaunch: %v", err)
+	}
+	if relaunched.Existing || relaunched.RunID == first.RunID {
+		t.Fatalf("relaunch = %+v, want a new run", relaunched)
+	}
+	stopApp(t, c)
+}
+
+func TestAuthFailure(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := newTestClient(t, ts, "wrong-token").Status(ctx)
+	if apiErr, ok := err.(*hookclient.APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status with wrong token: %v, want 401", err)
+	}
+
+	reader := newTestClient(t, ts, testReadToken)
+	if _, err := reader.Status(ctx); err != nil {
+		t.Fatalf("status with read token: %v", err)
+	}
+	_, err = reader.Start(ctx, &api.StartParams{})
+	if apiErr, ok := err.(*hookclient.APIError); !ok || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("start with read token: %v, want 403", err)
+	}
+}
@@ -0,0 +1,136 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+const (
+	SchemeTCP  = "tcp"
+	SchemeUnix = "unix"
+)
+
+// Address 是解析后的监听地址，例如 tcp://127.0.0.1:8080 或 unix:///run/wolf-hook.sock
+type Address struct {
+	Scheme string
+	Addr   string
+}
+
+func (a Address) String() string {
+	return fmt.Sprintf("%s://%s", a.Scheme, a.Addr)
+}
+
+// ParseAddress 解析监听地址，不带 scheme 时按 tcp 处理
+func ParseAddress(s string) (Address, error) {
+	scheme, addr := SchemeTCP, s
+	if idx := strings.Index(s, "://"); idx >= 0 {
+		scheme, addr = s[:idx], s[idx+3:]
+	}
+	switch scheme {
+	case SchemeTCP:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return Address{}, errors.Wrapf(err, "invalid tcp address %q", s)
+		}
+	case SchemeUnix:
+		if !strings.HasPrefix(addr, "/") {
+			return Address{}, errors.Errorf("unix socket path must be absolute: %q", s)
+		}
+	default:
+		return Address{}, errors.Errorf("unsupported listen scheme %q in %q", scheme, s)
+	}
+	return Address{Scheme: scheme, Addr: addr}, nil
+}
+
+// UnixSocketOptions 控制 unix socket 文件的权限和属主
+type UnixSocketOptions struct {
+	Mode os.FileMode
+	// UID/GID 小于 0 时不修改
+	UID int
+	GID int
+}
+
+// ParseOwner 解析 "uid:gid" 格式的属主，空字符串表示不修改
+func ParseOwner(s string) (int, int, error) {
+	if s == "" {
+		return -1, -1, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return -1, -1, errors.Wrapf(err, "invalid uid in %q", s)
+	}
+	gid := -1
+	if len(parts) == 2 && parts[1] != "" {
+		gid, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return -1, -1, errors.Wrapf(err, "invalid gid in %q", s)
+		}
+	}
+	return uid, gid, nil
+}
+
+// Listen 按地址创建监听，unix socket 会先清理残留的 socket 文件
+func Listen(addr Address, opts UnixSocketOptions) (net.Listener, error) {
+	switch addr.Scheme {
+	case SchemeTCP:
+		return net.Listen("tcp", addr.Addr)
+	case SchemeUnix:
+		return listenUnix(addr.Addr, opts)
+	}
+	return nil, errors.Errorf("unsupported listen scheme %q", addr.Scheme)
+}
+
+func listenUnix(path string, opts UnixSocketOptions) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen unix %s", path)
+	}
+	if opts.Mode != 0 {
+		if err := os.Chmod(path, opts.Mode); err != nil {
+			l.Close()
+			return nil, errors.Wrapf(err, "chmod %s", path)
+		}
+	}
+	if opts.UID >= 0 || opts.GID >= 0 {
+		if err := os.Chown(path, opts.UID, opts.GID); err != nil {
+			l.Close()
+			return nil, errors.Wrapf(err, "chown %s", path)
+		}
+	}
+	return l, nil
+}
+
+// removeStaleSocket 删除没有进程监听的 socket 文件，
+// 如果还有进程在监听或者路径不是 socket 则报错
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "stat %s", path)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return errors.Errorf("%s is in use by another process", path)
+	}
+	log.Infof("remove stale unix socket %s", path)
+	if err := os.Remove(path); err != nil {
+		return errors.Wrapf(err, "remove stale socket %s", path)
+	}
+	return nil
+}
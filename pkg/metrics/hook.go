@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var defaultRegistry = NewRegistry()
+
+func Default() *Registry {
+	return defaultRegistry
+}
+
+var (
+	HTTPRequests = NewCounterVec(defaultRegistry, "wolf_hook_http_requests_total",
+		"Total number of HTTP requests by route, method and status code.", "route", "method", "code")
+	HTTPRequestDuration = NewHistogramVec(defaultRegistry, "wolf_hook_http_request_duration_seconds",
+		"HTTP request latency by route and method.", nil, "route", "method")
+
+	AppState = NewGaugeVec(defaultRegistry, "wolf_hook_app_state",
+		"Current lifecycle state of the launched app, 1 for the active state.", "state")
+	AppRestarts = NewCounterVec(defaultRegistry, "wolf_hook_app_restarts_total",
+		"Number of times the app has been restarted by the restart policy or the watchdog.")
+
+	ZombiesReaped = NewCounterVec(defaultRegistry, "wolf_hook_zombies_reaped_total",
+		"Number of zombie processes reaped by the hook.")
+
+	ExecJobs = NewCounterVec(defaultRegistry, "wolf_hook_exec_jobs_total",
+		"Number of commands run through /hook/exec by result.", "result")
+	ExecDuration = NewHistogramVec(defaultRegistry, "wolf_hook_exec_duration_seconds",
+		"Duration of commands run through /hook/exec.", nil)
+
+	MoonlightAutoStartAttempts = NewCounterVec(defaultRegistry, "wolf_hook_moonlight_autostart_attempts_total",
+		"Number of moonlight client auto-start attempts.")
+	MoonlightAutoStartFailures = NewCounterVec(defaultRegistry, "wolf_hook_moonlight_autostart_failures_total",
+		"Number of failed moonlight client auto-start attempts.")
+)
+
+var (
+	appStartedAt time.Time
+	appStartLock sync.Mutex
+)
+
+func init() {
+	// 无标签的计数器初始化为 0，保证抓取时总能看到
+	for _, c := range []*CounterVec{AppRestarts, ZombiesReaped, MoonlightAutoStartAttempts, MoonlightAutoStartFailures} {
+		c.Add(0)
+	}
+	NewGaugeFunc(defaultRegistry, "wolf_hook_app_uptime_seconds",
+		"Seconds since the current app process was started, 0 when not running.", func() float64 {
+			appStartLock.Lock()
+			defer appStartLock.Unlock()
+
+			if appStartedAt.IsZero() {
+				return 0
+			}
+			return time.Since(appStartedAt).Seconds()
+		})
+}
+
+// SetAppStarted 记录应用启动时间，传入零值表示应用已停止
+func SetAppStarted(t time.Time) {
+	appStartLock.Lock()
+	defer appStartLock.Unlock()
+
+	appStartedAt = t
+}
+
+// SetAppState 把当前状态置为 1，其余状态置为 0
+func SetAppState(current string, all []string) {
+	for _, s := range all {
+		val := 0.0
+		if s == current {
+			val = 1
+		}
+		AppState.Set(val, s)
+	}
+}
+
+// ResultLabel 把错误转换为 success/failure 标签
+func ResultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush 保证事件流等需要 Flusher 的 handler 仍然可用
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// InstrumentHandler 是统计每个路由请求数和延迟的 mux 中间件
+func InstrumentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		HTTPRequests.Inc(route, r.Method, strconv.Itoa(rec.code))
+		HTTPRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
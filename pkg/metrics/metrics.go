@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 本包实现 Prometheus 文本格式所需的最小指标集合，避免引入完整的 client 库
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// DefaultBuckets 是以秒为单位的默认直方图分桶
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry 保存所有指标并以文本格式输出
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo 按指标名排序输出所有指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	cs := make([]collector, len(r.collectors))
+	copy(cs, r.collectors)
+	r.mu.Unlock()
+
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name() < cs[j].name() })
+	buf := new(bytes.Buffer)
+	for _, c := range cs {
+		c.write(buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler 返回输出指标的 http handler
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+type desc struct {
+	fqName string
+	help   string
+	typ    metricType
+	labels []string
+}
+
+func (d *desc) name() string {
+	return d.fqName
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, helpEscaper.Replace(d.help), d.fqName, d.typ)
+}
+
+// Prometheus 文本格式中 HELP 只转义反斜杠和换行，标签值还需要转义双引号，
+// 其余字符（包括非 ASCII 字符）按 UTF-8 原样输出
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s: expect %d label values, got %d", d.fqName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	var pairs []string
+	for i := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], labelValueEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelValueEscaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// valueVec 是 counter 和 gauge 共用的按标签存储的数值
+type valueVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	lvs    map[string][]string
+	// fn 不为空时在输出时计算数值，用于无标签的 gauge
+	fn func() float64
+}
+
+func newValueVec(reg *Registry, typ metricType, name, help string, labels []string) *valueVec {
+	v := &valueVec{
+		desc:   desc{fqName: name, help: help, typ: typ, labels: labels},
+		values: make(map[string]float64),
+		lvs:    make(map[string][]string),
+	}
+	reg.register(v)
+	return v
+}
+
+func (v *valueVec) add(delta float64, values []string) {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.values[key] += delta
+	v.lvs[key] = values
+}
+
+func (v *valueVec) set(val float64, values []string) {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.values[key] = val
+	v.lvs[key] = values
+}
+
+func (v *valueVec) write(w io.Writer) {
+	v.writeHeader(w)
+	if v.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", v.fqName, formatFloat(v.fn()))
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.fqName, formatLabels(v.labels, v.lvs[k]), formatFloat(v.values[k]))
+	}
+}
+
+// CounterVec 是只增不减的计数器
+type CounterVec struct {
+	*valueVec
+}
+
+func NewCounterVec(reg *Registry, name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newValueVec(reg, typeCounter, name, help, labels)}
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.add(1, values)
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can't decrease", c.fqName))
+	}
+	c.add(delta, values)
+}
+
+// GaugeVec 是可以任意设置的数值
+type GaugeVec struct {
+	*valueVec
+}
+
+func NewGaugeVec(reg *Registry, name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newValueVec(reg, typeGauge, name, help, labels)}
+}
+
+// NewGaugeFunc 创建一个在输出时才计算数值的 gauge
+func NewGaugeFunc(reg *Registry, name, help string, fn func() float64) {
+	v := newValueVec(reg, typeGauge, name, help, nil)
+	v.fn = fn
+}
+
+func (g *GaugeVec) Set(val float64, values ...string) {
+	g.set(val, values)
+}
+
+func (g *GaugeVec) Add(delta float64, values ...string) {
+	g.add(delta, values)
+}
+
+type histogramValue struct {
+	lvs     []string
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// HistogramVec 是按标签分组的直方图
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+func NewHistogramVec(reg *Registry, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{
+		desc:    desc{fqName: name, help: help, typ: typeHistogram, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	reg.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(val float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{lvs: values, buckets: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, b := range h.buckets {
+		if val <= b {
+			hv.buckets[i]++
+		}
+	}
+	hv.count++
+	hv.sum += val
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, formatLabels(h.labels, hv.lvs, "le", formatFloat(b)), hv.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.fqName, formatLabels(h.labels, hv.lvs, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fqName, formatLabels(h.labels, hv.lvs), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fqName, formatLabels(h.labels, hv.lvs), hv.count)
+	}
+}
@@ -14,11 +14,14 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"net/http"
 	"net/url"
 	"time"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/redact"
 )
 
 // PairResponse XML 响应结构体
@@ -270,9 +273,9 @@ func (c *MoonlightPairingClient) parseXMLResponse(resp *http.Response) (map[stri
 		return nil, fmt.Errorf("读取响应体失败: %v", err)
 	}
 
-	// 记录响应内容用于调试
+	// 响应中可能包含配对的挑战和证书，只在调试级别记录
 	bodyStr := string(body)
-	log.Printf("======= 响应内容: %s", bodyStr)
+	log.V(2).Infof("======= 响应内容: %s", bodyStr)
 
 	// 检查响应状态码
 	if resp.StatusCode != 200 {
@@ -311,8 +314,8 @@ func (c *MoonlightPairingClient) parseXMLResponse(resp *http.Response) (map[stri
 		return nil, fmt.Errorf("服务器返回错误: %s", pairResp.Error)
 	}
 
-	log.Printf("解析结果: %+v", result)
-	log.Printf("---pair resp: %+v", pairResp)
+	log.V(2).Infof("解析结果: %+v", result)
+	log.V(2).Infof("---pair resp: %+v", pairResp)
 	return result, nil
 }
 
@@ -333,16 +336,15 @@ func (c *MoonlightPairingClient) parseServerInfoResponse(resp *http.Response) (*
 		return nil, err
 	}
 
-	// 记录响应内容用于调试（原始输出）
 	bodyStr := string(body)
-	log.Printf("======= 服务器信息响应(原始):\n%s", bodyStr)
+	log.V(2).Infof("======= 服务器信息响应(原始):\n%s", bodyStr)
 
 	var serverInfo ServerInfo
 	if err := xml.Unmarshal(body, &serverInfo); err != nil {
 		return nil, err
 	}
-	log.Printf("======= 服务器信息响应(格式化):\n%s", prettyPrintXML(serverInfo))
-	log.Printf("服务器信息解析结果: %+v", serverInfo)
+	log.V(2).Infof("======= 服务器信息响应(格式化):\n%s", prettyPrintXML(serverInfo))
+	log.V(2).Infof("服务器信息解析结果: %+v", serverInfo)
 	return &serverInfo, nil
 }
 
@@ -353,9 +355,8 @@ func (c *MoonlightPairingClient) parseAppListResponse(resp *http.Response) (*App
 		return nil, fmt.Errorf("读取响应体失败: %v", err)
 	}
 
-	// 记录响应内容用于调试
 	bodyStr := string(body)
-	log.Printf("======= 应用列表响应: %s", bodyStr)
+	log.V(2).Infof("======= 应用列表响应: %s", bodyStr)
 
 	// 检查响应状态码
 	if resp.StatusCode != 200 {
@@ -368,7 +369,7 @@ func (c *MoonlightPairingClient) parseAppListResponse(resp *http.Response) (*App
 		return nil, fmt.Errorf("解析应用列表 XML 失败: %v", err)
 	}
 
-	log.Printf("应用列表解析结果: %+v", appList)
+	log.V(2).Infof("应用列表解析结果: %+v", appList)
 	return &appList, nil
 }
 
@@ -379,9 +380,8 @@ func (c *MoonlightPairingClient) parseLaunchResponse(resp *http.Response) (*Laun
 		return nil, fmt.Errorf("读取响应体失败: %v", err)
 	}
 
-	// 记录响应内容用于调试
 	bodyStr := string(body)
-	log.Printf("======= 启动应用响应: %s", bodyStr)
+	log.V(2).Infof("======= 启动应用响应: %s", bodyStr)
 
 	// 检查响应状态码
 	if resp.StatusCode != 200 {
@@ -399,7 +399,7 @@ func (c *MoonlightPairingClient) parseLaunchResponse(resp *http.Response) (*Laun
 		return nil, fmt.Errorf("启动应用失败: %s", launchResp.Error)
 	}
 
-	log.Printf("启动应用解析结果: %+v", launchResp)
+	log.V(2).Infof("启动应用解析结果: %+v", launchResp)
 	return &launchResp, nil
 }
 
@@ -801,7 +801,7 @@ func (c *MoonlightClient) LaunchApp(appID string, width, height, refreshRate int
 	// 设置必要的头部
 	req.Header.Set("User-Agent", "Moonlight-Go-Client/1.0")
 
-	log.Printf("发送启动应用请求到: %s", launchURL+"?"+params.Encode())
+	log.Printf("发送启动应用请求到: %s", launchURL+"?"+redact.Default().Values(params).Encode())
 
 	resp, err := c.httpsClient.Do(req)
 	if err != nil {
@@ -256,7 +256,7 @@ func (m *MoonlightInput) KeyboardKey(keyCode uint16, modifiers uint8, isPress bo
 
 	data := buf.Bytes()
 	inputType := KeyPress
-	log.Infof("======isPress: %v", isPress)
+	log.V(2).Infof("======isPress: %v", isPress)
 	if !isPress {
 		inputType = KeyRelease
 	}
@@ -316,7 +316,8 @@ func (m *MoonlightInput) SendInput(sessionID string, inputData []byte) error {
 	if err != nil {
 		return fmt.Errorf("marshal input data failed: %v", err)
 	}
-	log.Infof("=== Send input data: %s", string(jsonData))
+	// 每个输入事件都会发送，只在 log.verbose >= 2 时记录
+	log.V(2).Infof("=== Send input data: %s", string(jsonData))
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
@@ -0,0 +1,331 @@
+package ownership
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// SymlinkPolicy 决定遇到符号链接时的处理方式
+type SymlinkPolicy string
+
+const (
+	// SymlinkSkip 不处理符号链接
+	SymlinkSkip SymlinkPolicy = "skip"
+	// SymlinkLchown 修改符号链接本身的属主
+	SymlinkLchown SymlinkPolicy = "lchown"
+	// SymlinkFollow 修改符号链接指向的文件，但不会递归进入指向的目录
+	SymlinkFollow SymlinkPolicy = "follow"
+)
+
+// 报告中最多保留的修改记录数
+const maxReportChanges = 1000
+
+// Rule 把匹配的路径修改为指定的属主和权限。
+// 路径支持 filepath.Match 风格的通配符，相对路径基于 HOME；
+// UID/GID 为空时保持不变，FileMode/DirMode 为空时不修改权限
+type Rule struct {
+	// Name 用于在 /hook/ownership 中选择规则
+	Name     string   `json:"name,omitempty"`
+	Paths    []string `json:"paths"`
+	UID      *int     `json:"uid,omitempty"`
+	GID      *int     `json:"gid,omitempty"`
+	FileMode string   `json:"file_mode,omitempty"`
+	DirMode  string   `json:"dir_mode,omitempty"`
+	// Recursive 为 true 时处理目录下的所有文件
+	Recursive bool `json:"recursive,omitempty"`
+	// MaxDepth 限制递归的层数，0 表示不限制
+	MaxDepth int           `json:"max_depth,omitempty"`
+	Symlinks SymlinkPolicy `json:"symlinks,omitempty"`
+}
+
+// UnmarshalJSON 每次都从零值开始解析，避免加载配置文件时把列表元素合并到默认值上
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	type plain Rule
+	v := plain{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*r = Rule(v)
+	return nil
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.Errorf("invalid octal mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+func (r Rule) Validate() error {
+	if len(r.Paths) == 0 {
+		return errors.Errorf("paths is empty")
+	}
+	for _, p := range r.Paths {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return errors.Wrapf(err, "invalid path pattern %q", p)
+		}
+	}
+	if (r.UID != nil && *r.UID < 0) || (r.GID != nil && *r.GID < 0) {
+		return errors.Errorf("invalid uid/gid")
+	}
+	if r.FileMode != "" {
+		if _, err := parseMode(r.FileMode); err != nil {
+			return errors.Wrap(err, "file_mode")
+		}
+	}
+	if r.DirMode != "" {
+		if _, err := parseMode(r.DirMode); err != nil {
+			return errors.Wrap(err, "dir_mode")
+		}
+	}
+	if r.MaxDepth < 0 {
+		return errors.Errorf("max_depth must not be negative")
+	}
+	switch r.Symlinks {
+	case "", SymlinkSkip, SymlinkLchown, SymlinkFollow:
+	default:
+		return errors.Errorf("unknown symlinks policy %q", r.Symlinks)
+	}
+	return nil
+}
+
+// DefaultRules 返回没有配置规则时使用的规则，和之前固定修改 Steam 目录属主的行为一致
+func DefaultRules() []Rule {
+	uid, gid := 1000, 1000
+	return []Rule{
+		{Name: "steam", Paths: []string{".steam", ".steam/debian-installation"}, UID: &uid, GID: &gid},
+	}
+}
+
+// Change 是对一个路径的修改
+type Change struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Report 是执行规则的结果
+type Report struct {
+	DryRun bool `json:"dry_run"`
+	// Checked 是检查过的路径数
+	Checked int `json:"checked"`
+	// Changed 是修改过（DryRun 时为需要修改）的路径数
+	Changed int      `json:"changed"`
+	Changes []Change `json:"changes,omitempty"`
+	// Truncated 为 true 表示 Changes 只包含了部分修改
+	Truncated bool     `json:"truncated,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+func (r *Report) addChange(c Change) {
+	if len(r.Changes) >= maxReportChanges {
+		r.Truncated = true
+		return
+	}
+	r.Changes = append(r.Changes, c)
+}
+
+func (r *Report) addError(err error) {
+	log.Errorf("%v", err)
+	if len(r.Errors) >= maxReportChanges {
+		r.Truncated = true
+		return
+	}
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// Options 是执行规则的参数
+type Options struct {
+	// Home 用于解析相对路径，是应用运行用户的 HOME
+	Home   string
+	DryRun bool
+}
+
+// Apply 按顺序执行规则，单个路径失败不影响其余路径，所有失败记录在报告的 Errors 中
+func Apply(ctx context.Context, rules []Rule, opts Options) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return report, errors.Wrapf(err, "rules[%d]", i)
+		}
+		a := &applier{rule: rule, opts: opts, report: report}
+		if err := a.apply(ctx); err != nil {
+			return report, err
+		}
+	}
+	if len(report.Errors) > 0 {
+		return report, errors.Errorf("%d errors, first: %s", len(report.Errors), report.Errors[0])
+	}
+	return report, nil
+}
+
+type applier struct {
+	rule     Rule
+	opts     Options
+	report   *Report
+	fileMode os.FileMode
+	dirMode  os.FileMode
+}
+
+func (a *applier) apply(ctx context.Context) error {
+	if a.rule.FileMode != "" {
+		a.fileMode, _ = parseMode(a.rule.FileMode)
+	}
+	if a.rule.DirMode != "" {
+		a.dirMode, _ = parseMode(a.rule.DirMode)
+	}
+	for _, pattern := range a.rule.Paths {
+		if !filepath.IsAbs(pattern) {
+			if a.opts.Home == "" {
+				return errors.Errorf("目标用户的 HOME 未设置，无法解析相对路径 %s", pattern)
+			}
+			pattern = filepath.Join(a.opts.Home, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "glob %s", pattern)
+		}
+		if len(matches) == 0 {
+			log.Infof("路径 %s 不存在，跳过", pattern)
+			continue
+		}
+		for _, p := range matches {
+			if err := a.walk(ctx, p, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *applier) walk(ctx context.Context, path string, depth int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.report.addError(errors.Wrapf(err, "lstat %s", path))
+		}
+		return nil
+	}
+	a.report.Checked++
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		switch a.rule.Symlinks {
+		case SymlinkLchown:
+			a.fix(path, fi, true)
+		case SymlinkFollow:
+			target, err := os.Stat(path)
+			if err != nil {
+				a.report.addError(errors.Wrapf(err, "stat symlink target of %s", path))
+				return nil
+			}
+			a.fix(path, target, false)
+		}
+		return nil
+	}
+
+	a.fix(path, fi, false)
+	if !fi.IsDir() || !a.rule.Recursive || (a.rule.MaxDepth > 0 && depth >= a.rule.MaxDepth) {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		a.report.addError(errors.Wrapf(err, "read dir %s", path))
+		return nil
+	}
+	for _, entry := range entries {
+		if err := a.walk(ctx, filepath.Join(path, entry.Name()), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fix 修改单个路径的属主和权限，symlink 为 true 时修改符号链接本身且不修改权限
+func (a *applier) fix(path string, fi os.FileInfo, symlink bool) {
+	changed := false
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && (a.rule.UID != nil || a.rule.GID != nil) {
+		uid, gid := int(st.Uid), int(st.Gid)
+		newUID, newGID := uid, gid
+		if a.rule.UID != nil {
+			newUID = *a.rule.UID
+		}
+		if a.rule.GID != nil {
+			newGID = *a.rule.GID
+		}
+		if newUID != uid || newGID != gid {
+			changed = true
+			a.report.addChange(Change{
+				Path:   path,
+				Action: "chown",
+				From:   fmt.Sprintf("%d:%d", uid, gid),
+				To:     fmt.Sprintf("%d:%d", newUID, newGID),
+			})
+			if !a.opts.DryRun {
+				chown := os.Chown
+				if symlink {
+					chown = os.Lchown
+				}
+				if err := chown(path, newUID, newGID); err != nil {
+					a.report.addError(errors.Wrapf(err, "chown %s", path))
+				} else {
+					log.Infof("已设置 %s 属主为 %d:%d", path, newUID, newGID)
+				}
+			}
+		}
+	}
+
+	mode := a.fileMode
+	if fi.IsDir() {
+		mode = a.dirMode
+	}
+	if !symlink && mode != 0 && fi.Mode().Perm() != mode {
+		changed = true
+		a.report.addChange(Change{
+			Path:   path,
+			Action: "chmod",
+			From:   formatMode(fi.Mode().Perm()),
+			To:     formatMode(mode),
+		})
+		if !a.opts.DryRun {
+			// 保留 setuid/setgid/sticky 位
+			special := fi.Mode() & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+			if err := os.Chmod(path, mode|special); err != nil {
+				a.report.addError(errors.Wrapf(err, "chmod %s", path))
+			}
+		}
+	}
+	if changed {
+		a.report.Changed++
+	}
+}
+
+func formatMode(mode os.FileMode) string {
+	return "0" + strconv.FormatUint(uint64(mode), 8)
+}
+
+// Summary 返回报告的简要描述
+func (r *Report) Summary() string {
+	parts := []string{fmt.Sprintf("checked %d", r.Checked), fmt.Sprintf("changed %d", r.Changed)}
+	if r.DryRun {
+		parts = append(parts, "dry run")
+	}
+	if len(r.Errors) > 0 {
+		parts = append(parts, fmt.Sprintf("%d errors", len(r.Errors)))
+	}
+	return strings.Join(parts, ", ")
+}
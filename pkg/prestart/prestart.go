@@ -0,0 +1,170 @@
+package prestart
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+)
+
+// Context 是步骤执行时可以使用的信息
+type Context struct {
+	RunID  string
+	Config *config.Config
+	// Env 是应用启动时的环境变量，KEY=VALUE 格式
+	Env []string
+}
+
+// Getenv 返回应用环境变量中 key 的值，重复的变量以最后一个为准
+func (sc *Context) Getenv(key string) string {
+	for i := len(sc.Env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(sc.Env[i], key+"=") {
+			return sc.Env[i][len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// Step 是应用启动前的一个准备步骤
+type Step interface {
+	Name() string
+	// Run 执行步骤，超时后 ctx 会被取消
+	Run(ctx context.Context, sc *Context) error
+}
+
+// StepFunc 把函数包装为 Step
+type StepFunc struct {
+	StepName string
+	Fn       func(ctx context.Context, sc *Context) error
+}
+
+func (s StepFunc) Name() string {
+	return s.StepName
+}
+
+func (s StepFunc) Run(ctx context.Context, sc *Context) error {
+	return s.Fn(ctx, sc)
+}
+
+var (
+	registry     = make(map[string]Step)
+	registryLock sync.RWMutex
+)
+
+// Register 注册步骤，重复注册同名步骤会 panic
+func Register(step Step) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registry[step.Name()]; ok {
+		panic("prestart step " + step.Name() + " already registered")
+	}
+	registry[step.Name()] = step
+}
+
+func Get(name string) (Step, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	step, ok := registry[name]
+	return step, ok
+}
+
+// Names 返回所有已注册的步骤名
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateConfig 检查配置中的步骤都已注册并且没有重复
+func ValidateConfig(steps []config.StepConfig) error {
+	seen := make(map[string]bool)
+	for i, s := range steps {
+		if _, ok := Get(s.Name); !ok {
+			return errors.Errorf("prestart.steps[%d]: unknown step %q, available: %v", i, s.Name, Names())
+		}
+		if seen[s.Name] {
+			return errors.Errorf("prestart.steps[%d]: duplicate step %q", i, s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return nil
+}
+
+// Result 是一个步骤的执行结果
+type Result = api.StepResult
+
+// Run 按配置顺序执行步骤，每个步骤结束后调用 report；
+// 未设置 continue_on_failure 的步骤失败时停止执行并返回错误
+func Run(ctx context.Context, sc *Context, steps []config.StepConfig, report func(Result)) error {
+	for _, conf := range steps {
+		result := Result{
+			Name:              conf.Name,
+			ContinueOnFailure: conf.ContinueOnFailure,
+		}
+		if !conf.IsEnabled() {
+			log.Infof("prestart step %s is disabled, skip", conf.Name)
+			result.Skipped = true
+			report(result)
+			continue
+		}
+		step, ok := Get(conf.Name)
+		if !ok {
+			return errors.Errorf("unknown prestart step %q", conf.Name)
+		}
+
+		start := time.Now()
+		result.StartedAt = &start
+		err := runStep(ctx, step, sc, conf.Timeout.Duration())
+		result.DurationMs = time.Since(start).Milliseconds()
+		result.Success = err == nil
+		if err != nil {
+			result.Error = err.Error()
+		}
+		report(result)
+
+		if err != nil {
+			if conf.ContinueOnFailure {
+				log.Warningf("prestart step %s failed, continue: %v", conf.Name, err)
+				continue
+			}
+			return errors.Wrapf(err, "prestart step %s", conf.Name)
+		}
+		log.Infof("prestart step %s done in %dms", conf.Name, result.DurationMs)
+	}
+	return nil
+}
+
+// runStep 执行单个步骤，timeout 大于 0 时超时后不再等待步骤返回
+func runStep(ctx context.Context, step Step, sc *Context, timeout time.Duration) error {
+	if timeout <= 0 {
+		return step.Run(ctx, sc)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- step.Run(ctx, sc)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return errors.Errorf("timeout after %s", timeout)
+	}
+}
@@ -0,0 +1,105 @@
+package prestart
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/envfile"
+	"github.com/zexi/wolf-hook/pkg/events"
+	"github.com/zexi/wolf-hook/pkg/ownership"
+)
+
+const (
+	StepUdevControl = "udev-control"
+	StepOwnership   = "ownership"
+	StepEnvFile     = "env-file"
+)
+
+func init() {
+	Register(StepFunc{StepName: StepUdevControl, Fn: setupUdevControl})
+	Register(StepFunc{StepName: StepOwnership, Fn: setupOwnership})
+	Register(StepFunc{StepName: StepEnvFile, Fn: writeEnvFile})
+}
+
+// setupUdevControl 创建并设置 udev control 文件和目录
+func setupUdevControl(ctx context.Context, sc *Context) error {
+	udevDir := "/run/udev"
+	udevDataDir := "/run/udev/data"
+	controlFile := "/run/udev/control"
+
+	// 确保 udev 目录存在
+	if err := os.MkdirAll(udevDir, 0777); err != nil {
+		log.Errorf("创建目录 %s 失败: %v", udevDir, err)
+		return errors.Wrap(err, "创建 udev 目录失败")
+	}
+
+	// 创建 data 目录
+	if err := os.MkdirAll(udevDataDir, 0777); err != nil {
+		log.Errorf("创建目录 %s 失败: %v", udevDataDir, err)
+		return errors.Wrap(err, "创建 udev data 目录失败")
+	}
+
+	// 创建 control 文件
+	f, err := os.Create(controlFile)
+	if err != nil {
+		log.Errorf("创建文件 %s 失败: %v", controlFile, err)
+		return errors.Wrap(err, "创建 control 文件失败")
+	}
+	f.Close()
+
+	// 设置文件权限为 777
+	if err := os.Chmod(controlFile, 0777); err != nil {
+		log.Errorf("设置文件 %s 权限失败: %v", controlFile, err)
+		return errors.Wrap(err, "设置 control 文件权限失败")
+	}
+	return nil
+}
+
+// setupOwnership 按配置修改属主和权限，不存在的路径跳过。
+// 相对路径基于应用运行用户的 HOME，而不是 wolf-hook 自己的 HOME
+func setupOwnership(ctx context.Context, sc *Context) error {
+	conf := sc.Config.Ownership
+	report, err := ownership.Apply(ctx, conf.EffectiveRules(), ownership.Options{
+		Home:   sc.Getenv("HOME"),
+		DryRun: conf.DryRun,
+	})
+	if report != nil {
+		log.Infof("ownership rules: %s", report.Summary())
+		if conf.DryRun {
+			for _, c := range report.Changes {
+				log.Infof("[dry run] %s %s: %s -> %s", c.Action, c.Path, c.From, c.To)
+			}
+		}
+	}
+	return err
+}
+
+// writeEnvFile 按配置的格式和过滤规则写入环境变量文件
+func writeEnvFile(ctx context.Context, sc *Context) error {
+	conf := sc.Config.EnvFile
+	format, err := envfile.ParseFormat(conf.Format)
+	if err != nil {
+		return err
+	}
+	mode, err := strconv.ParseUint(conf.Mode, 8, 32)
+	if err != nil {
+		return errors.Wrapf(err, "invalid env file mode %q", conf.Mode)
+	}
+	content, skipped, err := envfile.Render(envfile.FromEnviron(sc.Env), format, conf.Filter)
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		log.Warningf("skip env with invalid names in %s: %v", conf.Path, skipped)
+	}
+	if err := envfile.WriteFile(conf.Path, content, os.FileMode(mode)); err != nil {
+		return err
+	}
+	log.Infof("env file %s written in %s format, %d bytes", conf.Path, format, len(content))
+	events.Publish(events.TypeFileWritten, sc.RunID, events.FileWrittenData{Path: conf.Path, Size: len(content)})
+	return nil
+}
@@ -0,0 +1,249 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/redact"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+// Type 是探测方式
+type Type string
+
+const (
+	// TypeProcessName 检查是否有进程名（/proc/<pid>/comm）等于 Target 的进程
+	TypeProcessName Type = "process_name"
+	// TypeCmdline 检查是否有命令行匹配正则 Target 的进程
+	TypeCmdline Type = "cmdline"
+	// TypePidfile 检查 pid 文件 Target 中记录的进程是否存活
+	TypePidfile Type = "pidfile"
+	// TypeUnixSocket 检查 unix socket Target 是否可以连接
+	TypeUnixSocket Type = "unix_socket"
+	// TypeTCP 检查 host:port 格式的 Target 是否可以连接
+	TypeTCP Type = "tcp"
+	// TypeFile 检查文件 Target 是否存在，例如 Wayland 或 X 的 socket 文件
+	TypeFile Type = "file"
+	// TypeCommand 执行命令 Target，退出码为 0 时认为成功
+	TypeCommand Type = "command"
+)
+
+// 内核中进程名最长 15 个字符
+const maxCommLen = 15
+
+const DefaultTimeout = 2 * time.Second
+
+// Config 是探测的配置
+type Config struct {
+	Type   Type   `json:"type"`
+	Target string `json:"target"`
+	// Args 是 command 探测的命令参数
+	Args []string `json:"args,omitempty"`
+	// Timeout 是 socket 探测的连接超时和 command 探测的执行超时，
+	// 以秒为单位或者 "2s" 这样的字符串，默认 2 秒
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Probe 检查一个条件是否满足，不满足时返回错误
+type Probe interface {
+	Check(ctx context.Context) error
+	String() string
+}
+
+// New 根据配置创建探测
+func New(conf Config) (Probe, error) {
+	if conf.Target == "" {
+		return nil, errors.Errorf("probe target is required")
+	}
+	timeout := DefaultTimeout
+	if conf.Timeout != "" {
+		d, err := parseDuration(conf.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		timeout = d
+	}
+	switch conf.Type {
+	case TypeProcessName:
+		return processName(conf.Target), nil
+	case TypeCmdline:
+		re, err := regexp.Compile(conf.Target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cmdline regexp %q", conf.Target)
+		}
+		return &cmdline{re: re}, nil
+	case TypePidfile:
+		return pidfile(conf.Target), nil
+	case TypeUnixSocket:
+		return &dial{network: "unix", addr: conf.Target, timeout: timeout}, nil
+	case TypeTCP:
+		if _, _, err := net.SplitHostPort(conf.Target); err != nil {
+			return nil, errors.Wrapf(err, "invalid tcp address %q", conf.Target)
+		}
+		return &dial{network: "tcp", addr: conf.Target, timeout: timeout}, nil
+	case TypeFile:
+		return file(conf.Target), nil
+	case TypeCommand:
+		if !filepath.IsAbs(conf.Target) {
+			return nil, errors.Errorf("command %q must be an absolute path", conf.Target)
+		}
+		return &command{path: conf.Target, args: conf.Args, timeout: timeout}, nil
+	}
+	return nil, errors.Errorf("unknown probe type %q", conf.Type)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid timeout %q", s)
+	}
+	return d, nil
+}
+
+type processName string
+
+func (p processName) Check(ctx context.Context) error {
+	name := string(p)
+	if len(name) > maxCommLen {
+		name = name[:maxCommLen]
+	}
+	procs, err := procutils.ListProcesses()
+	if err != nil {
+		return err
+	}
+	for _, proc := range procs {
+		if proc.Comm == name {
+			return nil
+		}
+	}
+	return errors.Errorf("no process named %q", string(p))
+}
+
+func (p processName) String() string {
+	return fmt.Sprintf("%s:%s", TypeProcessName, string(p))
+}
+
+type cmdline struct {
+	re *regexp.Regexp
+}
+
+func (c *cmdline) Check(ctx context.Context) error {
+	procs, err := procutils.ListProcesses()
+	if err != nil {
+		return err
+	}
+	for _, proc := range procs {
+		if proc.Cmdline != "" && c.re.MatchString(proc.Cmdline) {
+			return nil
+		}
+	}
+	return errors.Errorf("no process cmdline matches %q", c.re.String())
+}
+
+func (c *cmdline) String() string {
+	return fmt.Sprintf("%s:%s", TypeCmdline, c.re.String())
+}
+
+type pidfile string
+
+func (p pidfile) Check(ctx context.Context) error {
+	data, err := ioutil.ReadFile(string(p))
+	if err != nil {
+		return errors.Wrapf(err, "read pidfile")
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return errors.Errorf("invalid pid %q in %s", strings.TrimSpace(string(data)), string(p))
+	}
+	proc, err := procutils.ReadProcess(pid)
+	if err != nil {
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return errors.Errorf("process %d in %s not found", pid, string(p))
+		}
+		return errors.Wrapf(err, "read process %d", pid)
+	}
+	if proc.State == "Z" {
+		return errors.Errorf("process %d in %s is a zombie", pid, string(p))
+	}
+	return nil
+}
+
+func (p pidfile) String() string {
+	return fmt.Sprintf("%s:%s", TypePidfile, string(p))
+}
+
+type dial struct {
+	network string
+	addr    string
+	timeout time.Duration
+}
+
+func (d *dial) Check(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: d.timeout}
+	conn, err := dialer.DialContext(ctx, d.network, d.addr)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func (d *dial) String() string {
+	if d.network == "unix" {
+		return fmt.Sprintf("%s:%s", TypeUnixSocket, d.addr)
+	}
+	return fmt.Sprintf("%s:%s", TypeTCP, d.addr)
+}
+
+type file string
+
+func (f file) Check(ctx context.Context) error {
+	if _, err := os.Stat(string(f)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f file) String() string {
+	return fmt.Sprintf("%s:%s", TypeFile, string(f))
+}
+
+type command struct {
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+func (c *command) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	output, err := procutils.CombinedOutput(exec.CommandContext(ctx, c.path, c.args...))
+	if err != nil {
+		if out := strings.TrimSpace(string(output)); out != "" {
+			// 探测失败的原因会记录日志并发布事件，先隐藏输出中的敏感值
+			return errors.Wrapf(err, "%s", redact.Default().Text(out))
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *command) String() string {
+	return fmt.Sprintf("%s:%s", TypeCommand, strings.Join(append([]string{c.path}, redact.Default().Args(c.args)...), " "))
+}
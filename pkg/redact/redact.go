@@ -0,0 +1,194 @@
+package redact
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// Mask 替换被隐藏的值
+const Mask = "******"
+
+// DefaultPatterns 是默认隐藏的变量名模式
+var DefaultPatterns = []string{"*TOKEN*", "*PASSWORD*", "*PASSWD*", "*SECRET*", "*KEY*", "*CREDENTIAL*"}
+
+// Config 控制哪些变量的值在日志中隐藏，变量名匹配时不区分大小写
+type Config struct {
+	// Patterns 是变量名的 glob 模式
+	Patterns []string `json:"patterns"`
+	// Keys 是需要隐藏的变量名
+	Keys []string `json:"keys,omitempty"`
+	// Exclude 是不隐藏的变量名，优先于 Patterns 和 Keys
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Validate 检查 glob 模式是否合法
+func (c Config) Validate() error {
+	for _, p := range c.Patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// Redactor 隐藏敏感变量的值
+type Redactor struct {
+	patterns []string
+	keys     map[string]bool
+	exclude  map[string]bool
+}
+
+func New(conf Config) (*Redactor, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	r := &Redactor{
+		keys:    make(map[string]bool, len(conf.Keys)),
+		exclude: make(map[string]bool, len(conf.Exclude)),
+	}
+	for _, p := range conf.Patterns {
+		r.patterns = append(r.patterns, strings.ToUpper(p))
+	}
+	for _, k := range conf.Keys {
+		r.keys[strings.ToUpper(k)] = true
+	}
+	for _, k := range conf.Exclude {
+		r.exclude[strings.ToUpper(k)] = true
+	}
+	return r, nil
+}
+
+// IsSecret 返回变量 key 的值是否需要隐藏
+func (r *Redactor) IsSecret(key string) bool {
+	key = strings.ToUpper(key)
+	if r.exclude[key] {
+		return false
+	}
+	if r.keys[key] {
+		return true
+	}
+	for _, p := range r.patterns {
+		if ok, _ := filepath.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Value 在 key 需要隐藏时返回 Mask，否则返回 value
+func (r *Redactor) Value(key, value string) string {
+	if value != "" && r.IsSecret(key) {
+		return Mask
+	}
+	return value
+}
+
+// Env 返回隐藏了敏感值的 KEY=VALUE 列表的副本
+func (r *Redactor) Env(env []string) []string {
+	ret := make([]string, len(env))
+	for i, e := range env {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) == 2 {
+			e = pair[0] + "=" + r.Value(pair[0], pair[1])
+		}
+		ret[i] = e
+	}
+	return ret
+}
+
+// Map 返回隐藏了敏感值的副本
+func (r *Redactor) Map(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(m))
+	for k, v := range m {
+		ret[k] = r.Value(k, v)
+	}
+	return ret
+}
+
+// Values 返回隐藏了敏感参数的 URL 参数副本
+func (r *Redactor) Values(v url.Values) url.Values {
+	ret := make(url.Values, len(v))
+	for k, vals := range v {
+		for _, val := range vals {
+			ret.Add(k, r.Value(k, val))
+		}
+	}
+	return ret
+}
+
+// Args 返回隐藏了敏感值的命令行参数副本，支持 --token=xxx、--token xxx 和 TOKEN=xxx 三种形式
+func (r *Redactor) Args(args []string) []string {
+	ret := make([]string, len(args))
+	maskNext := false
+	for i, arg := range args {
+		if maskNext {
+			maskNext = false
+			if !strings.HasPrefix(arg, "-") {
+				ret[i] = Mask
+				continue
+			}
+		}
+		name := strings.TrimLeft(arg, "-")
+		if pair := strings.SplitN(name, "=", 2); len(pair) == 2 {
+			ret[i] = arg[:len(arg)-len(pair[1])] + r.Value(pair[0], pair[1])
+			continue
+		}
+		ret[i] = arg
+		maskNext = name != arg && name != "" && r.IsSecret(name)
+	}
+	return ret
+}
+
+var (
+	// textPairRe 匹配文本中 key=value、key: value 和 JSON 中 "key": "value" 形式的键值对
+	textPairRe = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_.-]*)("?\s*[=:]\s*)("[^"\n]*"|'[^'\n]*'|[^\s"',;&?}]+)`)
+	// textFlagRe 匹配文本中 --key value 形式的命令行参数
+	textFlagRe = regexp.MustCompile(`(^|\s)(--?[A-Za-z][A-Za-z0-9_.-]*)([ \t]+)([^\s-]\S*)`)
+)
+
+// Text 返回隐藏了文本中敏感键值对的值的副本，用于记录命令输出等不定格式的文本
+func (r *Redactor) Text(s string) string {
+	s = textPairRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := textPairRe.FindStringSubmatch(m)
+		if !r.IsSecret(sub[1]) {
+			return m
+		}
+		return sub[1] + sub[2] + Mask
+	})
+	return textFlagRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := textFlagRe.FindStringSubmatch(m)
+		if !r.IsSecret(strings.TrimLeft(sub[2], "-")) {
+			return m
+		}
+		return sub[1] + sub[2] + sub[3] + Mask
+	})
+}
+
+var (
+	defaultRedactor, _ = New(Config{Patterns: DefaultPatterns})
+	defaultLock        sync.RWMutex
+)
+
+// Default 返回全局使用的 Redactor
+func Default() *Redactor {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+
+	return defaultRedactor
+}
+
+// SetDefault 替换全局使用的 Redactor
+func SetDefault(r *Redactor) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+
+	defaultRedactor = r
+}
@@ -0,0 +1,221 @@
+package redact_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/zexi/wolf-hook/pkg/redact"
+)
+
+func newRedactor(t *testing.T, conf redact.Config) *redact.Redactor {
+	t.Helper()
+
+	r, err := redact.New(conf)
+	if err != nil {
+		t.Fatalf("new redactor: %v", err)
+	}
+	return r
+}
+
+func defaultRedactor(t *testing.T) *redact.Redactor {
+	return newRedactor(t, redact.Config{Patterns: redact.DefaultPatterns})
+}
+
+func TestIsSecret(t *testing.T) {
+	r := newRedactor(t, redact.Config{
+		Patterns: redact.DefaultPatterns,
+		Keys:     []string{"moonlight_pin"},
+		Exclude:  []string{"KEYBOARD_LAYOUT_KEY"},
+	})
+	cases := []struct {
+		key  string
+		want bool
+	}{
+		{"WOLF_TOKEN", true},
+		{"access_token", true},
+		{"Token", true},
+		{"DB_PASSWORD", true},
+		{"passwd", true},
+		{"CLIENT_SECRET", true},
+		{"API_KEY", true},
+		{"AWS_CREDENTIALS", true},
+		// Keys 精确匹配，不区分大小写
+		{"MOONLIGHT_PIN", true},
+		{"MOONLIGHT_PIN_HINT", false},
+		// Exclude 优先于 Patterns
+		{"keyboard_layout_key", false},
+		{"HOME", false},
+		{"PATH", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := r.IsSecret(c.key); got != c.want {
+			t.Errorf("IsSecret(%q) = %v, want %v", c.key, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if _, err := redact.New(redact.Config{Patterns: []string{"[TOKEN"}}); err == nil {
+		t.Errorf("invalid glob pattern accepted")
+	}
+}
+
+func TestValueEnvMap(t *testing.T) {
+	r := defaultRedactor(t)
+
+	if got := r.Value("TOKEN", ""); got != "" {
+		t.Errorf("empty secret value = %q, want empty", got)
+	}
+	if got := r.Value("TOKEN", "abc"); got != redact.Mask {
+		t.Errorf("secret value = %q, want mask", got)
+	}
+
+	env := []string{"HOME=/home/retro", "STEAM_TOKEN=abc=def", "BROKEN"}
+	want := []string{"HOME=/home/retro", "STEAM_TOKEN=" + redact.Mask, "BROKEN"}
+	if got := r.Env(env); !reflect.DeepEqual(got, want) {
+		t.Errorf("Env(%q) = %q, want %q", env, got, want)
+	}
+	if env[1] != "STEAM_TOKEN=abc=def" {
+		t.Errorf("Env modified its input")
+	}
+
+	m := map[string]string{"user": "retro", "Password": "pw"}
+	wantMap := map[string]string{"user": "retro", "Password": redact.Mask}
+	if got := r.Map(m); !reflect.DeepEqual(got, wantMap) {
+		t.Errorf("Map(%v) = %v, want %v", m, got, wantMap)
+	}
+	if r.Map(nil) != nil {
+		t.Errorf("Map(nil) is not nil")
+	}
+
+	v := url.Values{"access_token": {"a", "b"}, "page": {"1"}}
+	wantValues := url.Values{"access_token": {redact.Mask, redact.Mask}, "page": {"1"}}
+	if got := r.Values(v); !reflect.DeepEqual(got, wantValues) {
+		t.Errorf("Values(%v) = %v, want %v", v, got, wantValues)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	r := defaultRedactor(t)
+	cases := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "flag with equals",
+			args: []string{"--token=abc", "-password=pw", "--name=retro"},
+			want: []string{"--token=" + redact.Mask, "-password=" + redact.Mask, "--name=retro"},
+		},
+		{
+			name: "flag with separate value",
+			args: []string{"--api-key", "abc", "--verbose", "--name", "retro"},
+			want: []string{"--api-key", redact.Mask, "--verbose", "--name", "retro"},
+		},
+		{
+			// 敏感参数后面是另一个参数时没有值需要隐藏
+			name: "secret flag without value",
+			args: []string{"--token", "--debug"},
+			want: []string{"--token", "--debug"},
+		},
+		{
+			name: "environment assignment",
+			args: []string{"env", "STEAM_TOKEN=abc", "LANG=C", "app"},
+			want: []string{"env", "STEAM_TOKEN=" + redact.Mask, "LANG=C", "app"},
+		},
+		{
+			// 没有 - 前缀的参数不是参数名，后面的值保留
+			name: "positional secret word",
+			args: []string{"token", "abc"},
+			want: []string{"token", "abc"},
+		},
+		{
+			name: "empty value",
+			args: []string{"--token="},
+			want: []string{"--token="},
+		},
+		{
+			name: "empty",
+			args: []string{},
+			want: []string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			orig := append([]string{}, c.args...)
+			if got := r.Args(c.args); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Args(%q) = %q, want %q", c.args, got, c.want)
+			}
+			if !reflect.DeepEqual(c.args, orig) {
+				t.Errorf("Args modified its input")
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	r := defaultRedactor(t)
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "key equals value",
+			text: "login ok token=abc123 user=retro",
+			want: "login ok token=" + redact.Mask + " user=retro",
+		},
+		{
+			name: "key colon value",
+			text: "password: hunter2\nhome: /home/retro",
+			want: "password: " + redact.Mask + "\nhome: /home/retro",
+		},
+		{
+			name: "quoted values",
+			text: `secret="a b c" api_key='x y' name="retro"`,
+			want: `secret=` + redact.Mask + ` api_key=` + redact.Mask + ` name="retro"`,
+		},
+		{
+			name: "url query",
+			text: "GET http://host/pair?access_token=abc&page=2 failed",
+			want: "GET http://host/pair?access_token=" + redact.Mask + "&page=2 failed",
+		},
+		{
+			name: "json",
+			text: `{"user":"retro", "token": "abc", "api_key":12345}`,
+			want: `{"user":"retro", "token": ` + redact.Mask + `, "api_key":` + redact.Mask + `}`,
+		},
+		{
+			name: "flag with separate value",
+			text: "running app --password pw1 --name retro",
+			want: "running app --password " + redact.Mask + " --name retro",
+		},
+		{
+			name: "no secrets",
+			text: "exit status 1",
+			want: "exit status 1",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := r.Text(c.text); got != c.want {
+				t.Errorf("Text(%q) = %q, want %q", c.text, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	old := redact.Default()
+	defer redact.SetDefault(old)
+
+	if !old.IsSecret("WOLF_TOKEN") {
+		t.Errorf("default redactor does not hide tokens")
+	}
+	redact.SetDefault(newRedactor(t, redact.Config{Keys: []string{"PIN"}}))
+	if redact.Default().IsSecret("WOLF_TOKEN") || !redact.Default().IsSecret("pin") {
+		t.Errorf("SetDefault did not replace the default redactor")
+	}
+}
@@ -0,0 +1,292 @@
+package resources
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// ErrCgroupV2Unavailable 表示没有挂载 cgroup v2 的统一层级
+const ErrCgroupV2Unavailable = errors.Error("cgroup v2 is not available")
+
+// hookLeaf 是启用子树控制器前 wolf-hook 所在 cgroup 中的进程迁入的子组。
+// cgroup v2 中有进程的组不能为子组启用控制器
+const hookLeaf = "wolf-hook"
+
+// CgroupLimits 是应用所在 cgroup 的限制，为空的项不限制
+type CgroupLimits struct {
+	// CPUMax 写入 cpu.max，格式为 "$MAX $PERIOD"，例如 "200000 100000" 表示 2 个 CPU
+	CPUMax string `json:"cpu_max,omitempty"`
+	// MemoryMax 写入 memory.max，可以使用 K、M、G 后缀或者 "max"
+	MemoryMax string `json:"memory_max,omitempty"`
+	// PidsMax 写入 pids.max，数字或者 "max"
+	PidsMax string `json:"pids_max,omitempty"`
+}
+
+// Merge 用 o 中不为空的项覆盖 l
+func (l CgroupLimits) Merge(o CgroupLimits) CgroupLimits {
+	if o.CPUMax != "" {
+		l.CPUMax = o.CPUMax
+	}
+	if o.MemoryMax != "" {
+		l.MemoryMax = o.MemoryMax
+	}
+	if o.PidsMax != "" {
+		l.PidsMax = o.PidsMax
+	}
+	return l
+}
+
+// Validate 检查限制的格式，具体的取值范围由内核检查
+func (l CgroupLimits) Validate() error {
+	if l.CPUMax != "" {
+		fields := strings.Fields(l.CPUMax)
+		if len(fields) == 0 || len(fields) > 2 {
+			return errors.Errorf("invalid cpu_max %q", l.CPUMax)
+		}
+		if fields[0] != "max" {
+			if _, err := strconv.ParseUint(fields[0], 10, 64); err != nil {
+				return errors.Errorf("invalid cpu_max %q", l.CPUMax)
+			}
+		}
+		if len(fields) == 2 {
+			if _, err := strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return errors.Errorf("invalid cpu_max period %q", l.CPUMax)
+			}
+		}
+	}
+	if l.MemoryMax != "" && l.MemoryMax != "max" {
+		num := strings.TrimRight(l.MemoryMax, "KMGkmg")
+		if _, err := strconv.ParseUint(num, 10, 64); err != nil || len(l.MemoryMax)-len(num) > 1 {
+			return errors.Errorf("invalid memory_max %q", l.MemoryMax)
+		}
+	}
+	if l.PidsMax != "" && l.PidsMax != "max" {
+		if _, err := strconv.ParseUint(l.PidsMax, 10, 64); err != nil {
+			return errors.Errorf("invalid pids_max %q", l.PidsMax)
+		}
+	}
+	return nil
+}
+
+// files 返回各控制器的限制文件和要写入的值，为空的项为 "max"
+func (l CgroupLimits) files() map[string]string {
+	ret := map[string]string{
+		"cpu":    "max",
+		"memory": "max",
+		"pids":   "max",
+	}
+	if l.CPUMax != "" {
+		ret["cpu"] = l.CPUMax
+	}
+	if l.MemoryMax != "" {
+		ret["memory"] = l.MemoryMax
+	}
+	if l.PidsMax != "" {
+		ret["pids"] = l.PidsMax
+	}
+	return ret
+}
+
+// controllers 返回设置了限制的控制器
+func (l CgroupLimits) controllers() []string {
+	var ret []string
+	for controller, value := range l.files() {
+		if value != "max" {
+			ret = append(ret, controller)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Cgroup 是应用所在的 cgroup v2 组
+type Cgroup struct {
+	Path string
+}
+
+// Usage 是 cgroup 当前的资源使用
+type Usage struct {
+	Cgroup        string `json:"cgroup"`
+	MemoryCurrent uint64 `json:"memory_current"`
+	PidsCurrent   uint64 `json:"pids_current"`
+	CPUUsageUsec  uint64 `json:"cpu_usage_usec"`
+}
+
+// IsCgroupV2 返回 root 是否是 cgroup v2 的统一层级
+func IsCgroupV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// selfCgroup 返回当前进程在 cgroup v2 中的路径
+func selfCgroup() (string, error) {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", errors.Wrap(err, "read /proc/self/cgroup")
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", ErrCgroupV2Unavailable
+}
+
+func readFileString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func writeFile(path, content string) error {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return errors.Wrapf(err, "write %q to %s", content, path)
+	}
+	return nil
+}
+
+// enableControllers 为 base 的子组启用控制器，base 不是根组且其中有进程时先把进程迁入 hookLeaf 子组。
+// 根组不受只有叶子组可以有进程的限制
+func enableControllers(base string, isRoot bool, controllers []string) error {
+	available, err := readFileString(filepath.Join(base, "cgroup.controllers"))
+	if err != nil {
+		return errors.Wrap(err, "read available controllers")
+	}
+	enabled, err := readFileString(filepath.Join(base, "cgroup.subtree_control"))
+	if err != nil {
+		return errors.Wrap(err, "read subtree controllers")
+	}
+	var missing []string
+	for _, c := range controllers {
+		if !containsField(available, c) {
+			return errors.Errorf("controller %s is not available in %s", c, base)
+		}
+		if !containsField(enabled, c) {
+			missing = append(missing, "+"+c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	procs := ""
+	if !isRoot {
+		if procs, err = readFileString(filepath.Join(base, "cgroup.procs")); err != nil {
+			return errors.Wrap(err, "read cgroup procs")
+		}
+	}
+	if procs != "" {
+		leaf := filepath.Join(base, hookLeaf)
+		if err := os.MkdirAll(leaf, 0755); err != nil {
+			return errors.Wrapf(err, "create %s", leaf)
+		}
+		for _, pid := range strings.Fields(procs) {
+			// 内核线程等无法迁移的进程忽略
+			if err := writeFile(filepath.Join(leaf, "cgroup.procs"), pid); err != nil {
+				log.Warningf("move pid %s to %s: %v", pid, leaf, err)
+			}
+		}
+	}
+	return writeFile(filepath.Join(base, "cgroup.subtree_control"), strings.Join(missing, " "))
+}
+
+// isRootCgroup 返回 path 是否是真正的根组。
+// 容器默认使用私有的 cgroup namespace，/proc/self/cgroup 中容器所在的组显示为 "/"，
+// 但它不是根组，同样受只有叶子组可以有进程的限制。只有根组没有 cgroup.type 文件
+func isRootCgroup(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "cgroup.type"))
+	return os.IsNotExist(err)
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupCgroup 在 wolf-hook 所在的 cgroup 下创建名为 name 的子组并写入限制
+func SetupCgroup(root, name string, limits CgroupLimits) (*Cgroup, error) {
+	if !IsCgroupV2(root) {
+		return nil, ErrCgroupV2Unavailable
+	}
+	self, err := selfCgroup()
+	if err != nil {
+		return nil, err
+	}
+	// 之前已经把自己迁入了 hookLeaf
+	if filepath.Base(self) == hookLeaf {
+		self = filepath.Dir(self)
+	}
+	base := filepath.Join(root, self)
+	if err := enableControllers(base, isRootCgroup(base), limits.controllers()); err != nil {
+		return nil, err
+	}
+	cg := &Cgroup{Path: filepath.Join(base, name)}
+	if err := os.MkdirAll(cg.Path, 0755); err != nil {
+		return nil, errors.Wrapf(err, "create cgroup %s", cg.Path)
+	}
+	for controller, value := range limits.files() {
+		path := filepath.Join(cg.Path, controller+".max")
+		// 没有启用的控制器没有限制文件，不需要清除上一次运行的限制
+		if _, err := os.Stat(path); os.IsNotExist(err) && value == "max" {
+			continue
+		}
+		if err := writeFile(path, value); err != nil {
+			return nil, err
+		}
+	}
+	return cg, nil
+}
+
+// AddProcess 把进程迁入该组
+func (c *Cgroup) AddProcess(pid int) error {
+	return writeFile(filepath.Join(c.Path, "cgroup.procs"), strconv.Itoa(pid))
+}
+
+// Usage 读取当前的内存、进程数和 CPU 时间，没有启用的控制器的项为 0
+func (c *Cgroup) Usage() (*Usage, error) {
+	u := &Usage{Cgroup: c.Path}
+	readUint := func(file string) (uint64, error) {
+		s, err := readFileString(filepath.Join(c.Path, file))
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(s, 10, 64)
+	}
+	var err error
+	if u.MemoryCurrent, err = readUint("memory.current"); err != nil {
+		return nil, errors.Wrap(err, "read memory.current")
+	}
+	if u.PidsCurrent, err = readUint("pids.current"); err != nil {
+		return nil, errors.Wrap(err, "read pids.current")
+	}
+	f, err := os.Open(filepath.Join(c.Path, "cpu.stat"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read cpu.stat")
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			u.CPUUsageUsec, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,99 @@
+package resources
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"syscall"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// Unlimited 对应 RLIM_INFINITY
+const Unlimited = Limit(^uint64(0))
+
+// syscall 包中没有定义的资源
+const (
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
+
+// rlimitResources 是允许为应用设置的资源限制
+var rlimitResources = map[string]int{
+	"nofile":  syscall.RLIMIT_NOFILE,
+	"memlock": rlimitMemlock,
+	"nproc":   rlimitNproc,
+	"core":    syscall.RLIMIT_CORE,
+}
+
+// Limit 是一个资源限制值，JSON 中可以是数字或者 "unlimited"
+type Limit uint64
+
+func (l Limit) MarshalJSON() ([]byte, error) {
+	if l == Unlimited {
+		return json.Marshal("unlimited")
+	}
+	return json.Marshal(uint64(l))
+}
+
+func (l *Limit) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case float64:
+		if val == -1 {
+			*l = Unlimited
+			return nil
+		}
+		if val < 0 {
+			return errors.Errorf("invalid limit %v", val)
+		}
+		return json.Unmarshal(b, (*uint64)(l))
+	case string:
+		if val == "unlimited" || val == "infinity" {
+			*l = Unlimited
+			return nil
+		}
+	}
+	return errors.Errorf("invalid limit %s", string(b))
+}
+
+// Rlimit 是一个资源的软限制和硬限制
+type Rlimit struct {
+	Soft Limit `json:"soft"`
+	Hard Limit `json:"hard"`
+}
+
+// ValidateRlimits 检查资源名称是否支持以及软限制是否超过硬限制
+func ValidateRlimits(limits map[string]Rlimit) error {
+	for name, l := range limits {
+		if _, ok := rlimitResources[name]; !ok {
+			return errors.Errorf("unsupported rlimit %q, supported: %s", name, strings.Join(RlimitNames(), ", "))
+		}
+		if l.Soft > l.Hard {
+			return errors.Errorf("rlimit %s: soft limit %d exceeds hard limit %d", name, l.Soft, l.Hard)
+		}
+	}
+	return nil
+}
+
+// RlimitNames 返回支持的资源名称
+func RlimitNames() []string {
+	names := make([]string, 0, len(rlimitResources))
+	for name := range rlimitResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RlimitResource 返回资源名称对应的 setrlimit 资源编号
+func RlimitResource(name string) (int, error) {
+	res, ok := rlimitResources[name]
+	if !ok {
+		return 0, errors.Errorf("unsupported rlimit %q", name)
+	}
+	return res, nil
+}
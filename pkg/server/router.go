@@ -0,0 +1,68 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zexi/wolf-hook/pkg/auth"
+	"github.com/zexi/wolf-hook/pkg/handlers"
+	"github.com/zexi/wolf-hook/pkg/metrics"
+)
+
+const requestTimeout = 15 * time.Second
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPISpec 返回 hook API 的 OpenAPI 文档
+func OpenAPISpec() []byte {
+	return openAPISpec
+}
+
+// withTimeout 限制普通请求的处理时间
+func withTimeout(h http.Handler) http.Handler {
+	return http.TimeoutHandler(h, requestTimeout, "request timeout")
+}
+
+// withStartTimeout 和 withTimeout 相同，但 wait=true 的启动请求不限制处理时间，
+// 等待时间由就绪检查的超时决定
+func withStartTimeout(h http.Handler) http.Handler {
+	timed := withTimeout(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") == "true" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		timed.ServeHTTP(w, r)
+	})
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// NewRouter 返回注册了所有 hook API 路由的 handler
+func NewRouter(a *auth.Authenticator) http.Handler {
+	r := mux.NewRouter()
+	r.Use(metrics.InstrumentHandler)
+	// API 文档有意不需要鉴权，客户端在拿到凭据前也可以获取
+	r.Handle("/openapi.json", http.HandlerFunc(serveOpenAPI)).Methods("GET")
+	r.Handle("/metrics", withTimeout(a.Require(auth.ScopeRead, metrics.Default().Handler()))).Methods("GET")
+	r.Handle("/hook/start", withStartTimeout(a.Require(auth.ScopeStart, handlers.NewStartController()))).Methods("POST")
+	// 停止时等待的时间由 grace_period 决定，不限制处理时间
+	r.Handle("/hook/stop", a.Require(auth.ScopeStop, handlers.NewStopController())).Methods("POST")
+	r.Handle("/hook/signal", withTimeout(a.Require(auth.ScopeStop, handlers.NewSignalController()))).Methods("POST")
+	r.Handle("/hook/status", withTimeout(a.Require(auth.ScopeRead, handlers.NewGetStatusController()))).Methods("GET")
+	r.Handle("/hook/processes", withTimeout(a.Require(auth.ScopeRead, handlers.NewProcessesController()))).Methods("GET")
+	r.Handle("/hook/events", a.Require(auth.ScopeRead, handlers.NewEventsController())).Methods("GET")
+	r.Handle("/hook/logs", a.Require(auth.ScopeRead, handlers.NewLogsController())).Methods("GET")
+	r.Handle("/hook/exec", withTimeout(a.Require(auth.ScopeExec, handlers.NewExecController()))).Methods("POST")
+	r.Handle("/hook/write-hwdb", withTimeout(a.Require(auth.ScopeWrite, handlers.NewWriteHwdbController()))).Methods("POST")
+	r.Handle("/hook/ownership", withTimeout(a.Require(auth.ScopeWrite, handlers.NewOwnershipController()))).Methods("POST")
+	r.Handle("/steam/owned-games", withTimeout(a.Require(auth.ScopeRead, handlers.NewSteamOwnedGamesController()))).Methods("GET")
+	return r
+}
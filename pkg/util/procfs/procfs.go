@@ -0,0 +1,262 @@
+package procfs
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// Root 是 procfs 的挂载点
+var Root = "/proc"
+
+// clockTicks 是 /proc/<pid>/stat 中时间字段的单位，Linux 上 USER_HZ 固定为 100
+const clockTicks = 100
+
+// pageSize 用于把 rss 页数转换为字节数，arm64 等平台上可能是 16K 或 64K
+var pageSize = int64(os.Getpagesize())
+
+// Stat 是 /proc/<pid>/stat 中用到的字段
+type Stat struct {
+	Pid   int
+	Comm  string
+	State string
+	PPid  int
+	Pgid  int
+	// Session 是会话 id
+	Session int
+	// UTime 和 STime 是用户态和内核态的 CPU 时间，单位为 clock tick
+	UTime uint64
+	STime uint64
+	// StartTime 是进程启动时距离系统启动的时间，单位为 clock tick
+	StartTime uint64
+	// RSS 是常驻内存的页数
+	RSS int64
+}
+
+// stat 文件中进程名之后的字段下标，从 state 开始
+const (
+	idxState     = 0
+	idxPPid      = 1
+	idxPgrp      = 2
+	idxSession   = 3
+	idxUTime     = 11
+	idxSTime     = 12
+	idxStartTime = 19
+	idxRSS       = 21
+)
+
+// ParseStat 解析 /proc/<pid>/stat 的内容。
+// 进程名可能包含空格和括号，以第一个 '(' 和最后一个 ')' 之间的内容作为进程名
+func ParseStat(data []byte) (*Stat, error) {
+	str := strings.TrimSpace(string(data))
+	start := strings.IndexByte(str, '(')
+	end := strings.LastIndexByte(str, ')')
+	if start < 0 || end < start {
+		return nil, errors.Errorf("invalid stat %q", str)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(str[:start]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pid in stat %q", str)
+	}
+	fields := strings.Fields(str[end+1:])
+	if len(fields) < 3 {
+		return nil, errors.Errorf("invalid stat %q", str)
+	}
+	st := &Stat{
+		Pid:   pid,
+		Comm:  str[start+1 : end],
+		State: fields[idxState],
+	}
+	if st.PPid, err = strconv.Atoi(fields[idxPPid]); err != nil {
+		return nil, errors.Wrapf(err, "invalid ppid in stat %q", str)
+	}
+	if st.Pgid, err = strconv.Atoi(fields[idxPgrp]); err != nil {
+		return nil, errors.Wrapf(err, "invalid pgrp in stat %q", str)
+	}
+	// 旧内核或者正在退出的进程可能缺少后面的字段，缺少时保持为 0
+	if len(fields) > idxRSS {
+		st.Session, _ = strconv.Atoi(fields[idxSession])
+		st.UTime, _ = strconv.ParseUint(fields[idxUTime], 10, 64)
+		st.STime, _ = strconv.ParseUint(fields[idxSTime], 10, 64)
+		st.StartTime, _ = strconv.ParseUint(fields[idxStartTime], 10, 64)
+		st.RSS, _ = strconv.ParseInt(fields[idxRSS], 10, 64)
+	}
+	return st, nil
+}
+
+func pidDir(pid int) string {
+	return filepath.Join(Root, strconv.Itoa(pid))
+}
+
+// ReadStat 读取并解析 /proc/<pid>/stat
+func ReadStat(pid int) (*Stat, error) {
+	data, err := ioutil.ReadFile(filepath.Join(pidDir(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	st, err := ParseStat(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pid %d", pid)
+	}
+	return st, nil
+}
+
+// ReadCmdline 返回以空格连接的命令行参数，内核线程为空
+func ReadCmdline(pid int) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(pidDir(pid), "cmdline"))
+	if err != nil {
+		return "", err
+	}
+	args := strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00")
+	return strings.Join(args, " "), nil
+}
+
+// ReadUID 返回进程的真实 uid
+func ReadUID(pid int) (int, error) {
+	f, err := os.Open(filepath.Join(pidDir(pid), "status"))
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Uid:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Uid:"))
+		if len(fields) == 0 {
+			break
+		}
+		return strconv.Atoi(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return -1, err
+	}
+	return -1, errors.Errorf("no Uid in status of pid %d", pid)
+}
+
+// Pids 返回 /proc 中所有进程的 pid，按从小到大排序
+func Pids() ([]int, error) {
+	dirs, err := ioutil.ReadDir(Root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s dir", Root)
+	}
+	pids := make([]int, 0, len(dirs))
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+var (
+	bootTime     time.Time
+	bootTimeErr  error
+	bootTimeOnce sync.Once
+)
+
+// BootTime 返回 /proc/stat 中记录的系统启动时间
+func BootTime() (time.Time, error) {
+	bootTimeOnce.Do(func() {
+		data, err := ioutil.ReadFile(filepath.Join(Root, "stat"))
+		if err != nil {
+			bootTimeErr = err
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(line, "btime ") {
+				continue
+			}
+			sec, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "btime ")), 10, 64)
+			if err != nil {
+				bootTimeErr = errors.Wrapf(err, "invalid btime %q", line)
+				return
+			}
+			bootTime = time.Unix(sec, 0)
+			return
+		}
+		bootTimeErr = errors.Errorf("no btime in %s/stat", Root)
+	})
+	return bootTime, bootTimeErr
+}
+
+// Process 是从 /proc 中读取的进程信息
+type Process struct {
+	Pid   int    `json:"pid"`
+	PPid  int    `json:"ppid"`
+	Pgid  int    `json:"pgid"`
+	State string `json:"state"`
+	Comm  string `json:"comm"`
+	// Cmdline 是以空格连接的命令行参数，内核线程为空
+	Cmdline string `json:"cmdline"`
+	// UID 读取失败时为 -1
+	UID       int       `json:"uid"`
+	StartTime time.Time `json:"start_time"`
+	RSSBytes  int64     `json:"rss_bytes"`
+	// CPUTimeMs 是用户态和内核态 CPU 时间之和
+	CPUTimeMs int64 `json:"cpu_time_ms"`
+}
+
+// ReadProcess 读取单个进程的信息
+func ReadProcess(pid int) (*Process, error) {
+	st, err := ReadStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	cmdline, err := ReadCmdline(pid)
+	if err != nil {
+		return nil, err
+	}
+	p := &Process{
+		Pid:       pid,
+		PPid:      st.PPid,
+		Pgid:      st.Pgid,
+		State:     st.State,
+		Comm:      st.Comm,
+		Cmdline:   cmdline,
+		RSSBytes:  st.RSS * pageSize,
+		CPUTimeMs: int64((st.UTime + st.STime) * 1000 / clockTicks),
+	}
+	if p.UID, err = ReadUID(pid); err != nil {
+		p.UID = -1
+	}
+	if boot, err := BootTime(); err == nil {
+		p.StartTime = boot.Add(time.Duration(st.StartTime) * time.Second / clockTicks)
+	}
+	return p, nil
+}
+
+// ListProcesses 返回所有进程，读取过程中退出的进程会被忽略
+func ListProcesses() ([]*Process, error) {
+	pids, err := Pids()
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]*Process, 0, len(pids))
+	for _, pid := range pids {
+		p, err := ReadProcess(pid)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
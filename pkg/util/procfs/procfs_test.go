@@ -0,0 +1,109 @@
+package procfs_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zexi/wolf-hook/pkg/util/procfs"
+)
+
+// statTail 是进程名之后从 state 到 rss 的字段，和真实的 /proc/<pid>/stat 一致
+const statTail = "S 1 100 100 0 -1 4194560 1000 0 0 0 250 50 0 0 20 0 1 0 12345 10000000 321"
+
+func TestParseStat(t *testing.T) {
+	full := &procfs.Stat{
+		Pid: 42, State: "S", PPid: 1, Pgid: 100, Session: 100,
+		UTime: 250, STime: 50, StartTime: 12345, RSS: 321,
+	}
+	withComm := func(comm string) *procfs.Stat {
+		st := *full
+		st.Comm = comm
+		return &st
+	}
+
+	cases := []struct {
+		name    string
+		data    string
+		want    *procfs.Stat
+		wantErr bool
+	}{
+		{
+			name: "plain comm",
+			data: "42 (sway) " + statTail + "\n",
+			want: withComm("sway"),
+		},
+		{
+			name: "comm with spaces",
+			data: "42 (Web Content) " + statTail,
+			want: withComm("Web Content"),
+		},
+		{
+			name: "comm with parentheses",
+			data: "42 (a) (b)) " + statTail,
+			want: withComm("a) (b)"),
+		},
+		{
+			name: "empty comm",
+			data: "42 () " + statTail,
+			want: withComm(""),
+		},
+		{
+			// 缺少后面的字段时只解析 state、ppid 和 pgrp
+			name: "short line",
+			data: "42 (zombie) Z 1 100",
+			want: &procfs.Stat{Pid: 42, Comm: "zombie", State: "Z", PPid: 1, Pgid: 100},
+		},
+		{
+			name:    "too few fields",
+			data:    "42 (sway) S 1",
+			wantErr: true,
+		},
+		{
+			name:    "missing comm",
+			data:    "42 sway S 1 100",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated comm",
+			data:    "42 (sway S 1 100",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric pid",
+			data:    "x42 (sway) " + statTail,
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric ppid",
+			data:    "42 (sway) S one 100",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric pgrp",
+			data:    "42 (sway) S 1 group",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			data:    "",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := procfs.ParseStat([]byte(c.data))
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("ParseStat(%q) = %+v, want error", c.data, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseStat(%q): %v", c.data, err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ParseStat(%q) = %+v, want %+v", c.data, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package procutils
+
+import (
+	"syscall"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/util/procfs"
+)
+
+// GroupMembers 返回进程组中所有非僵尸进程的 pid
+func GroupMembers(pgid int) ([]int, error) {
+	all, err := procfs.Pids()
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, pid := range all {
+		st, err := procfs.ReadStat(pid)
+		if err != nil {
+			continue
+		}
+		if st.State == "Z" || st.Pgid != pgid {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// SignalGroup 向进程组发送信号，进程组不存在时不报错
+func SignalGroup(pgid int, sig syscall.Signal) error {
+	if pgid <= 1 {
+		return errors.Errorf("refuse to signal process group %d", pgid)
+	}
+	if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+		return errors.Wrapf(err, "kill -%d %s", pgid, sig)
+	}
+	return nil
+}
+
+// WaitGroupExit 等待进程组中的进程全部退出，超时返回 false
+func WaitGroupExit(pgid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		pids, err := GroupMembers(pgid)
+		if err == nil && len(pids) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
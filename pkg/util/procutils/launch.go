@@ -0,0 +1,170 @@
+package procutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"yunion.io/x/pkg/errors"
+)
+
+const (
+	// launchHelperArg 是 wolf-hook 作为启动辅助进程运行时的第一个参数
+	launchHelperArg = "__wolf_hook_launch"
+	// 辅助进程中的管道，分别对应 ExtraFiles[0] 和 ExtraFiles[1]
+	launchOptionsFd = 3
+	launchErrorFd   = 4
+	// launchExecFailedCode 是辅助进程 exec 失败时的退出码，和 shell 一致
+	launchExecFailedCode = 127
+)
+
+// LaunchRlimit 是辅助进程 exec 前设置的一个资源限制
+type LaunchRlimit struct {
+	Name     string         `json:"name"`
+	Resource int            `json:"resource"`
+	Limit    syscall.Rlimit `json:"limit"`
+}
+
+// LaunchOptions 是辅助进程在 exec 前对自身做的设置
+type LaunchOptions struct {
+	// Umask 小于 0 时不修改
+	Umask   int            `json:"umask"`
+	Rlimits []LaunchRlimit `json:"rlimits,omitempty"`
+	// Credential 在设置资源限制后切换，由 GateCommand 从 cmd.SysProcAttr 中取出
+	Credential *syscall.Credential `json:"credential,omitempty"`
+}
+
+// LaunchGate 让命令在 exec 前停住，父进程可以在应用的代码运行前把它迁入 cgroup，
+// 资源限制、umask 和用户由辅助进程在 exec 前设置，不需要临时修改 wolf-hook 自身的设置
+type LaunchGate struct {
+	opts         LaunchOptions
+	optsR, optsW *os.File
+	errR, errW   *os.File
+}
+
+// GateCommand 把 cmd 改为先运行 wolf-hook 自身的启动辅助进程，pid 保持不变。
+// 辅助进程以 wolf-hook 的身份启动，Release 后按 opts 设置资源限制、切换用户、设置 umask，
+// 然后 exec 原来的命令。需要在 main 开始时调用 RunLaunchHelper
+func GateCommand(cmd *exec.Cmd, opts LaunchOptions) (*LaunchGate, error) {
+	if len(cmd.ExtraFiles) > 0 {
+		return nil, errors.Errorf("gated command can't have extra files")
+	}
+	g := &LaunchGate{opts: opts}
+	// 切换用户后无法再提高硬限制，也无法通过 prlimit 修改，由辅助进程设置资源限制后再切换
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+		g.opts.Credential = cmd.SysProcAttr.Credential
+		cmd.SysProcAttr.Credential = nil
+	}
+	var err error
+	if g.optsR, g.optsW, err = os.Pipe(); err != nil {
+		return nil, errors.Wrap(err, "create options pipe")
+	}
+	if g.errR, g.errW, err = os.Pipe(); err != nil {
+		g.Close()
+		return nil, errors.Wrap(err, "create error pipe")
+	}
+	args := []string{"wolf-hook-launch", launchHelperArg, cmd.Path}
+	cmd.Args = append(args, cmd.Args...)
+	// 使用 /proc/self/exe，wolf-hook 的文件被替换后仍然是当前运行的程序
+	cmd.Path = "/proc/self/exe"
+	cmd.ExtraFiles = []*os.File{g.optsR, g.errW}
+	return g, nil
+}
+
+// Release 把设置发给辅助进程并让它 exec 原来的命令，设置或者 exec 失败时返回辅助进程报告的错误。
+// 需要在命令启动后调用
+func (g *LaunchGate) Release() error {
+	// 父进程中子进程一端的副本需要关闭，否则读不到 EOF
+	g.optsR.Close()
+	g.errW.Close()
+	err := json.NewEncoder(g.optsW).Encode(g.opts)
+	g.optsW.Close()
+	if err != nil {
+		g.errR.Close()
+		return errors.Wrap(err, "send launch options")
+	}
+	// exec 成功时管道因为 close-on-exec 被关闭，读到 EOF
+	msg, err := ioutil.ReadAll(g.errR)
+	g.errR.Close()
+	if err != nil {
+		return errors.Wrap(err, "read launch helper error")
+	}
+	if len(msg) > 0 {
+		return errors.Error(string(msg))
+	}
+	return nil
+}
+
+// Close 放弃启动，辅助进程读不到设置后退出而不会 exec 原来的命令
+func (g *LaunchGate) Close() {
+	for _, f := range []*os.File{g.optsR, g.optsW, g.errR, g.errW} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// RunLaunchHelper 在 wolf-hook 作为启动辅助进程运行时等待父进程放行并 exec 应用，不会返回；
+// 不是辅助进程时直接返回
+func RunLaunchHelper() {
+	if len(os.Args) < 4 || os.Args[1] != launchHelperArg {
+		return
+	}
+	path, argv := os.Args[2], os.Args[3:]
+
+	optsPipe := os.NewFile(launchOptionsFd, "launch-options")
+	errPipe := os.NewFile(launchErrorFd, "launch-error")
+	syscall.CloseOnExec(launchErrorFd)
+	data, err := ioutil.ReadAll(optsPipe)
+	optsPipe.Close()
+	if err != nil || len(data) == 0 {
+		// 父进程放弃了启动
+		os.Exit(1)
+	}
+	opts := new(LaunchOptions)
+	if err := json.Unmarshal(data, opts); err != nil {
+		fmt.Fprintf(errPipe, "decode launch options: %v", err)
+		os.Exit(launchExecFailedCode)
+	}
+	if err := opts.apply(); err != nil {
+		fmt.Fprint(errPipe, err.Error())
+		os.Exit(launchExecFailedCode)
+	}
+	err = syscall.Exec(path, argv, os.Environ())
+	fmt.Fprintf(errPipe, "fork/exec %s: %v", path, err)
+	os.Exit(launchExecFailedCode)
+}
+
+// apply 在辅助进程中设置资源限制、用户和 umask，顺序和 exec.Cmd 切换用户的方式一致
+func (opts *LaunchOptions) apply() error {
+	for _, l := range opts.Rlimits {
+		limit := l.Limit
+		if err := syscall.Setrlimit(l.Resource, &limit); err != nil {
+			return errors.Wrapf(err, "setrlimit %s", l.Name)
+		}
+	}
+	if cred := opts.Credential; cred != nil {
+		if !cred.NoSetGroups {
+			groups := make([]int, len(cred.Groups))
+			for i, g := range cred.Groups {
+				groups[i] = int(g)
+			}
+			if err := syscall.Setgroups(groups); err != nil {
+				return errors.Wrap(err, "setgroups")
+			}
+		}
+		if err := syscall.Setgid(int(cred.Gid)); err != nil {
+			return errors.Wrapf(err, "setgid %d", cred.Gid)
+		}
+		if err := syscall.Setuid(int(cred.Uid)); err != nil {
+			return errors.Wrapf(err, "setuid %d", cred.Uid)
+		}
+	}
+	if opts.Umask >= 0 {
+		syscall.Umask(opts.Umask)
+	}
+	return nil
+}
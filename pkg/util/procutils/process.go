@@ -0,0 +1,67 @@
+package procutils
+
+import (
+	"os"
+
+	"github.com/zexi/wolf-hook/pkg/util/procfs"
+)
+
+// Process 是从 /proc 中读取的进程信息
+type Process = procfs.Process
+
+// ReadProcess 读取单个进程的信息
+func ReadProcess(pid int) (*Process, error) {
+	return procfs.ReadProcess(pid)
+}
+
+// ListProcesses 返回所有非僵尸进程，读取过程中退出的进程会被忽略
+func ListProcesses() ([]*Process, error) {
+	procs, err := procfs.ListProcesses()
+	if err != nil {
+		return nil, err
+	}
+	ret := procs[:0]
+	for _, p := range procs {
+		if p.State != "Z" {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
+// IsAlive 返回进程是否存在且不是僵尸进程
+func IsAlive(pid int) bool {
+	st, err := procfs.ReadStat(pid)
+	return err == nil && st.State != "Z"
+}
+
+// Tree 返回进程组 pgid 中的进程以及它们的所有子孙进程，
+// 子孙进程即使调用 setsid 离开了进程组也会包含在内。结果不包括当前进程
+func Tree(pgid int) ([]*Process, error) {
+	procs, err := ListProcesses()
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]*Process)
+	var queue []*Process
+	for _, p := range procs {
+		children[p.PPid] = append(children[p.PPid], p)
+		if p.Pgid == pgid {
+			queue = append(queue, p)
+		}
+	}
+	self := os.Getpid()
+	seen := make(map[int]bool)
+	var ret []*Process
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p.Pid] || p.Pid == self {
+			continue
+		}
+		seen[p.Pid] = true
+		ret = append(ret, p)
+		queue = append(queue, children[p.Pid]...)
+	}
+	return ret, nil
+}
@@ -0,0 +1,228 @@
+package procutils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"github.com/zexi/wolf-hook/pkg/metrics"
+)
+
+// reapInterval 是没有收到 SIGCHLD 时兜底回收的间隔，SIGCHLD 可能被合并或者丢失
+const reapInterval = 30 * time.Second
+
+// ExitError 是由回收器得到退出状态的命令的非零退出，和 exec.ExitError 对应
+type ExitError struct {
+	Pid    int
+	Status syscall.WaitStatus
+}
+
+func (e *ExitError) Error() string {
+	switch {
+	case e.Status.Exited():
+		return fmt.Sprintf("exit status %d", e.Status.ExitStatus())
+	case e.Status.Signaled():
+		if e.Status.CoreDump() {
+			return fmt.Sprintf("signal: %s (core dumped)", e.Status.Signal())
+		}
+		return fmt.Sprintf("signal: %s", e.Status.Signal())
+	}
+	return fmt.Sprintf("wait status %d", int(e.Status))
+}
+
+// ExitCode 返回退出码，被信号终止时为 -1，和 os.ProcessState.ExitCode 一致
+func (e *ExitError) ExitCode() int {
+	return e.Status.ExitStatus()
+}
+
+// prSetChildSubreaper 是 prctl 的 PR_SET_CHILD_SUBREAPER
+const prSetChildSubreaper = 36
+
+// ReaperOptions 是子进程回收器的选项
+type ReaperOptions struct {
+	// Subreaper 为 true 时把当前进程设置为 child subreaper，
+	// 不是 1 号进程时也可以收养并回收子孙进程中的孤儿进程
+	Subreaper bool
+	// OnOrphanExit 在回收不是通过 StartCommand 启动的进程时调用
+	OnOrphanExit func(pid int, ws syscall.WaitStatus)
+}
+
+// reaper 在收到 SIGCHLD 时回收所有退出的子进程。
+// 通过 StartCommand 启动的进程的退出状态交给 WaitCommand，其余进程直接回收
+type reaper struct {
+	mu      sync.Mutex
+	running bool
+	// owned 是通过 StartCommand 启动、还没有退出的进程
+	owned        map[int]chan syscall.WaitStatus
+	onOrphanExit func(pid int, ws syscall.WaitStatus)
+}
+
+var defaultReaper = &reaper{
+	owned: make(map[int]chan syscall.WaitStatus),
+}
+
+// StartReaper 在后台回收子进程。1 号进程或者设置了 Subreaper 时才会收养孤儿进程，
+// 否则不需要回收
+func StartReaper(ctx context.Context, opts ReaperOptions) {
+	if opts.Subreaper {
+		if err := setChildSubreaper(); err != nil {
+			log.Errorf("set child subreaper: %v", err)
+		} else {
+			log.Infof("act as child subreaper of pid %d", os.Getpid())
+		}
+	} else if os.Getpid() != 1 {
+		log.Infof("My pid is not 1 and no need to wait zombies")
+		return
+	}
+	defaultReaper.start(ctx, opts.OnOrphanExit)
+}
+
+// setChildSubreaper 让当前进程收养子孙进程中的孤儿进程
+func setChildSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (r *reaper) start(ctx context.Context, onOrphanExit func(pid int, ws syscall.WaitStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running {
+		return
+	}
+	r.running = true
+	r.onOrphanExit = onOrphanExit
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGCHLD)
+	go r.loop(ctx, sigCh)
+}
+
+func (r *reaper) loop(ctx context.Context, sigCh chan os.Signal) {
+	defer signal.Stop(sigCh)
+
+	tick := time.NewTicker(reapInterval)
+	defer tick.Stop()
+	for {
+		// 回调在释放 mu 之后执行，回调中可以启动新的命令
+		for _, o := range r.reap() {
+			if r.onOrphanExit != nil {
+				r.onOrphanExit(o.pid, o.status)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+		case <-tick.C:
+		}
+	}
+}
+
+// orphanExit 是一个被回收的孤儿进程
+type orphanExit struct {
+	pid    int
+	status syscall.WaitStatus
+}
+
+// reap 回收所有已经退出的子进程，返回其中的孤儿进程。
+// 持有 mu 期间 StartCommand 不会启动新的进程，保证进程在登记之前不会被当作孤儿进程回收
+func (r *reaper) reap() []orphanExit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var orphans []orphanExit
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			if err != syscall.ECHILD {
+				log.Errorf("wait children: %v", err)
+			}
+			return orphans
+		}
+		if pid <= 0 {
+			return orphans
+		}
+		if ch, ok := r.owned[pid]; ok {
+			delete(r.owned, pid)
+			ch <- ws
+			continue
+		}
+		log.Infof("pid %d: wait done, status %d", pid, ws.ExitStatus())
+		metrics.ZombiesReaped.Inc()
+		orphans = append(orphans, orphanExit{pid: pid, status: ws})
+	}
+}
+
+// StartCommand 启动命令，回收器运行时登记进程，退出状态需要通过 WaitCommand 获取
+func StartCommand(cmd *exec.Cmd) error {
+	r := defaultReaper
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if r.running {
+		r.owned[cmd.Process.Pid] = make(chan syscall.WaitStatus, 1)
+	}
+	return nil
+}
+
+// WaitCommand 等待 StartCommand 启动的命令退出，返回值和 exec.Cmd.Wait 相同，
+// 只是由回收器得到退出状态时非零退出返回 *ExitError
+func WaitCommand(cmd *exec.Cmd) error {
+	r := defaultReaper
+	r.mu.Lock()
+	ch, ok := r.owned[cmd.Process.Pid]
+	r.mu.Unlock()
+	if !ok {
+		return cmd.Wait()
+	}
+
+	ws := <-ch
+	// 进程已经被回收，Wait 返回 ECHILD，这里只用于等待输出复制完成并释放资源
+	if err := cmd.Wait(); err != nil && !isNoChildError(err) {
+		return err
+	}
+	if ws.Exited() && ws.ExitStatus() == 0 {
+		return nil
+	}
+	return &ExitError{Pid: cmd.Process.Pid, Status: ws}
+}
+
+func isNoChildError(err error) bool {
+	if syscallErr, ok := err.(*os.SyscallError); ok {
+		return syscallErr.Err == syscall.ECHILD
+	}
+	return err == syscall.ECHILD
+}
+
+// CombinedOutput 和 exec.Cmd.CombinedOutput 相同，但是通过 StartCommand 和 WaitCommand 运行
+func CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, errors.Errorf("exec: Stdout or Stderr already set")
+	}
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	if err := StartCommand(cmd); err != nil {
+		return b.Bytes(), err
+	}
+	err := WaitCommand(cmd)
+	return b.Bytes(), err
+}
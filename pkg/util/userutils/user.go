@@ -0,0 +1,154 @@
+package userutils
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// 用户和组数据库文件，不依赖 cgo 和 NSS
+var (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+)
+
+const (
+	ErrUserNotFound  = errors.Error("user not found")
+	ErrGroupNotFound = errors.Error("group not found")
+)
+
+// User 是 /etc/passwd 中的一条记录
+type User struct {
+	Name  string
+	UID   int
+	GID   int
+	Home  string
+	Shell string
+}
+
+// Group 是 /etc/group 中的一条记录
+type Group struct {
+	Name    string
+	GID     int
+	Members []string
+}
+
+// readEntries 读取冒号分隔的数据库文件，跳过空行、注释和字段数不足的行
+func readEntries(path string, minFields int, fn func(fields []string) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < minFields {
+			continue
+		}
+		if fn(fields) {
+			return nil
+		}
+	}
+	return errors.Wrapf(scanner.Err(), "read %s", path)
+}
+
+func parseUser(fields []string) (*User, bool) {
+	uid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, false
+	}
+	gid, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, false
+	}
+	return &User{Name: fields[0], UID: uid, GID: gid, Home: fields[5], Shell: fields[6]}, true
+}
+
+func parseGroup(fields []string) (*Group, bool) {
+	gid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, false
+	}
+	g := &Group{Name: fields[0], GID: gid}
+	if fields[3] != "" {
+		g.Members = strings.Split(fields[3], ",")
+	}
+	return g, true
+}
+
+// LookupUser 按用户名或者数字 uid 查找用户
+func LookupUser(nameOrID string) (*User, error) {
+	var found *User
+	err := readEntries(PasswdFile, 7, func(fields []string) bool {
+		u, ok := parseUser(fields)
+		if !ok {
+			return false
+		}
+		if u.Name == nameOrID || strconv.Itoa(u.UID) == nameOrID {
+			found = u
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errors.Wrapf(ErrUserNotFound, "%q", nameOrID)
+	}
+	return found, nil
+}
+
+// LookupGroup 按组名或者数字 gid 查找组
+func LookupGroup(nameOrID string) (*Group, error) {
+	var found *Group
+	err := readEntries(GroupFile, 4, func(fields []string) bool {
+		g, ok := parseGroup(fields)
+		if !ok {
+			return false
+		}
+		if g.Name == nameOrID || strconv.Itoa(g.GID) == nameOrID {
+			found = g
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errors.Wrapf(ErrGroupNotFound, "%q", nameOrID)
+	}
+	return found, nil
+}
+
+// SupplementaryGroups 返回用户所属的所有组 id，包括主组
+func SupplementaryGroups(u *User) ([]int, error) {
+	gids := []int{u.GID}
+	err := readEntries(GroupFile, 4, func(fields []string) bool {
+		g, ok := parseGroup(fields)
+		if !ok || g.GID == u.GID {
+			return false
+		}
+		for _, m := range g.Members {
+			if m == u.Name {
+				gids = append(gids, g.GID)
+				break
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gids, nil
+}
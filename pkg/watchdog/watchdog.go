@@ -0,0 +1,141 @@
+package watchdog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/probe"
+)
+
+// Status 是看门狗的状态
+type Status = api.WatchdogStatus
+
+// Options 是看门狗的参数
+type Options struct {
+	Probe            probe.Probe
+	InitialDelay     time.Duration
+	Interval         time.Duration
+	FailureThreshold int
+	// Action 是动作的名称，只用于展示
+	Action string
+	// OnFailure 在连续失败达到阈值时调用，返回 true 时继续检查，否则看门狗停止
+	OnFailure func(err error) bool
+	// OnUpdate 在每次检查后调用
+	OnUpdate func(Status)
+}
+
+// Watchdog 定期执行探测，连续失败达到阈值后执行动作
+type Watchdog struct {
+	opts   Options
+	mu     sync.Mutex
+	status Status
+}
+
+func New(opts Options) *Watchdog {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 1
+	}
+	return &Watchdog{
+		opts: opts,
+		status: Status{
+			Probe:            opts.Probe.String(),
+			Healthy:          true,
+			FailureThreshold: opts.FailureThreshold,
+			Action:           opts.Action,
+		},
+	}
+}
+
+func (w *Watchdog) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.status
+}
+
+// Run 执行检查直到 ctx 结束或者动作要求停止
+func (w *Watchdog) Run(ctx context.Context) {
+	log.Infof("watchdog started: probe %s, interval %s, failure threshold %d, action %s",
+		w.opts.Probe, w.opts.Interval, w.opts.FailureThreshold, w.opts.Action)
+	w.update()
+	if !sleep(ctx, w.opts.InitialDelay) {
+		return
+	}
+	for {
+		if !w.check(ctx) {
+			return
+		}
+		if !sleep(ctx, w.opts.Interval) {
+			return
+		}
+	}
+}
+
+// check 执行一次检查，返回是否继续
+func (w *Watchdog) check(ctx context.Context) bool {
+	err := w.opts.Probe.Check(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+	now := time.Now()
+
+	w.mu.Lock()
+	w.status.LastCheck = &now
+	if err == nil {
+		w.status.Healthy = true
+		w.status.ConsecutiveFailures = 0
+		w.status.LastError = ""
+	} else {
+		w.status.Healthy = false
+		w.status.ConsecutiveFailures++
+		w.status.LastError = err.Error()
+	}
+	failures := w.status.ConsecutiveFailures
+	triggered := err != nil && failures >= w.opts.FailureThreshold
+	if triggered {
+		w.status.TriggeredAt = &now
+	}
+	w.mu.Unlock()
+	w.update()
+
+	if err == nil {
+		return true
+	}
+	log.Warningf("watchdog probe %s failed (%d/%d): %v", w.opts.Probe, failures, w.opts.FailureThreshold, err)
+	if !triggered {
+		return true
+	}
+	log.Warningf("watchdog probe %s failed %d times, run action %s", w.opts.Probe, failures, w.opts.Action)
+	cont := w.opts.OnFailure(err)
+	if cont {
+		w.mu.Lock()
+		w.status.ConsecutiveFailures = 0
+		w.mu.Unlock()
+		w.update()
+	}
+	return cont
+}
+
+func (w *Watchdog) update() {
+	if w.opts.OnUpdate != nil {
+		w.opts.OnUpdate(w.Status())
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
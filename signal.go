@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"yunion.io/x/log"
+
+	"github.com/zexi/wolf-hook/pkg/api"
+	"github.com/zexi/wolf-hook/pkg/config"
+	"github.com/zexi/wolf-hook/pkg/handlers"
+	"github.com/zexi/wolf-hook/pkg/util/procutils"
+)
+
+const serverShutdownTimeout = 5 * time.Second
+
+// handleSignals 以 init 进程的方式处理信号：
+// 指定了 reload 时 SIGHUP 用于重新加载配置，否则和 SIGUSR1/SIGUSR2 一样转发给应用进程组；
+// SIGTERM/SIGINT/SIGQUIT 转发后等待应用退出，超时发送 SIGKILL，然后关闭 HTTP 服务并退出
+func handleSignals(srv *http.Server, reload func()) {
+	sigCh := make(chan os.Signal, 8)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range sigCh {
+		s := sig.(syscall.Signal)
+		if isTerminateSignal(s) {
+			shutdown(srv, s, sigCh, config.Get().Shutdown.GracePeriod.Duration())
+			return
+		}
+		if s == syscall.SIGHUP && reload != nil {
+			reload()
+			continue
+		}
+		forwardSignal(s)
+	}
+}
+
+// handleReloadSignal 在非 init 模式下收到 SIGHUP 时重新加载配置
+func handleReloadSignal(reload func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	for range sigCh {
+		reload()
+	}
+}
+
+func isTerminateSignal(s syscall.Signal) bool {
+	switch s {
+	case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
+		return true
+	}
+	return false
+}
+
+func forwardSignal(s syscall.Signal) {
+	if handlers.AppProcessGroup() == 0 {
+		log.Infof("received %s, no app launched to forward to", s)
+		return
+	}
+	log.Infof("forward %s to app process group", s)
+	if err := handlers.SignalApp(s); err != nil {
+		log.Errorf("forward %s to app: %v", s, err)
+	}
+}
+
+// shutdown 停止应用进程组和 HTTP 服务后退出。
+// 退出码：应用被 SIGKILL 时为 137；应用入口进程已退出时为其退出码；否则为 128+信号值
+func shutdown(srv *http.Server, sig syscall.Signal, sigCh <-chan os.Signal, gracePeriod time.Duration) {
+	log.Infof("received %s, shutting down with grace period %s", sig, gracePeriod)
+	code := 128 + int(sig)
+	handlers.DisableRestart()
+	if err := handlers.SetState(api.STATE_STOPPING); err != nil {
+		log.Warningf("set state stopping: %v", err)
+	}
+
+	if pgid := handlers.AppProcessGroup(); pgid != 0 {
+		forwardSignal(sig)
+		done := make(chan bool, 1)
+		go func() {
+			done <- procutils.WaitGroupExit(pgid, gracePeriod)
+		}()
+		exited := false
+	wait:
+		for {
+			select {
+			case exited = <-done:
+				break wait
+			case s := <-sigCh:
+				// 再次收到终止信号时不再等待
+				if isTerminateSignal(s.(syscall.Signal)) {
+					log.Warningf("received %s again, kill app immediately", s)
+					break wait
+				}
+				forwardSignal(s.(syscall.Signal))
+			}
+		}
+		if !exited {
+			log.Warningf("app process group %d still alive, send SIGKILL", pgid)
+			if err := procutils.SignalGroup(pgid, syscall.SIGKILL); err != nil {
+				log.Errorf("kill app process group %d: %v", pgid, err)
+			}
+			procutils.WaitGroupExit(pgid, serverShutdownTimeout)
+			code = 128 + int(syscall.SIGKILL)
+		} else if appCode, ok := handlers.WaitAppExitCode(serverShutdownTimeout); ok {
+			code = appCode
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorf("shutdown http server: %v", err)
+	}
+	log.Infof("======exit code %d", code)
+	os.Exit(code)
+}